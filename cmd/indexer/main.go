@@ -5,19 +5,85 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Adit0507/wiki-search-engine/internal/indexer"
 )
 
 func main() {
 	var (
-		dataPath  = flag.String("data", "./data/wikipedia", "Path to wikipedia data")
-		indexPath = flag.String("index", "./indexes", "Path to store indexes")
-		workers   = flag.Int("workers", 4, "No. of worker goroutines")
+		dataPath   = flag.String("data", "./data/wikipedia", "Path to wikipedia data")
+		indexPath  = flag.String("index", "./indexes", "Path to store indexes")
+		workers    = flag.Int("workers", 4, "No. of worker goroutines")
+		namespaces = flag.String("namespaces", "0", "Comma separated namespace ids to index")
+		lang       = flag.String("lang", "", "Wiki language code (en, de, fr, es...), detected from the dump when empty")
+		resume     = flag.Bool("resume", false, "Continue from the last checkpoint in the index path")
+		every      = flag.Duration("checkpoint-every", 10*time.Minute, "How often to checkpoint while parsing, 0 to disable")
+		format     = flag.String("format", "", "Input format ("+strings.Join(indexer.Formats, ", ")+"), detected from each file's extension when empty")
+		update     = flag.Bool("update", false, "Apply the data to the existing index as an update instead of rebuilding it")
+		prune      = flag.Bool("prune", false, "With -update, treat the data as a complete dump and delete indexed pages it doesn't have")
+		compact    = flag.Bool("compact", false, "Fold the saved updates into the index and exit")
+		quarantine = flag.String("quarantine", "", "JSONL file the pages skipped for bad or missing content are listed in (default <index>/quarantine.jsonl)")
+		memory     = flag.Int("memory", 0, "MB of parsed text and postings to hold before flushing a segment to disk, and of the runs each later stage holds before spilling, 0 keeps everything in memory until parsing is done")
+
+		// for small dev indexes
+		maxDocs    = flag.Int("max-docs", 0, "Stop after indexing this many documents, 0 for no limit")
+		sample     = flag.Float64("sample", 1, "Share of titles to index, picked by a hash of the title so every run gets the same ones")
+		sampleSeed = flag.Uint64("sample-seed", 0, "Seed for -sample, a different seed picks a different sample")
+		include    = flag.String("include", "", "Only index titles matching this regexp")
+		exclude    = flag.String("exclude", "", "Skip titles matching this regexp")
+		titleList  = flag.String("titles", "", "File with the titles to index, one per line")
 	)
 	flag.Parse()
 
+	if *resume && *update {
+		log.Fatal("-resume and -update can't be combined")
+	}
+
+	if *compact {
+		idx := indexer.NewIndexer(*indexPath, *workers, indexer.Options{Language: *lang})
+		if err := idx.Compact(); err != nil {
+			log.Fatal("Error compacting index: ", err)
+		}
+		return
+	}
+
+	if *format != "" && !slices.Contains(indexer.Formats, *format) {
+		log.Fatalf("Invalid -format %q, use one of %s", *format, strings.Join(indexer.Formats, ", "))
+	}
+
+	nsIDs, err := parseIntList(*namespaces)
+	if err != nil {
+		log.Fatal("Invalid -namespaces: ", err)
+	}
+
+	if *sample <= 0 || *sample > 1 {
+		log.Fatalf("Invalid -sample %v, use a share between 0 and 1", *sample)
+	}
+	selection := indexer.Selection{MaxDocs: *maxDocs, SampleRate: *sample, SampleSeed: *sampleSeed}
+	if *include != "" {
+		if selection.Include, err = regexp.Compile(*include); err != nil {
+			log.Fatal("Invalid -include: ", err)
+		}
+	}
+	if *exclude != "" {
+		if selection.Exclude, err = regexp.Compile(*exclude); err != nil {
+			log.Fatal("Invalid -exclude: ", err)
+		}
+	}
+	if *titleList != "" {
+		if selection.Titles, err = indexer.ReadTitleList(*titleList); err != nil {
+			log.Fatal("Failed to read -titles: ", err)
+		}
+	}
+
 	if err := os.MkdirAll(*indexPath, 0755); err != nil {
 		log.Fatal("Failed to create index directory: ", err)
 	}
@@ -26,26 +92,106 @@ func main() {
 	fmt.Printf("Data path: %s\n", *dataPath)
 	fmt.Printf("Index path: %s\n", *indexPath)
 	fmt.Printf("Workers: %d\n", *workers)
+	fmt.Printf("Namespaces: %v\n", nsIDs)
 
-	idx := indexer.NewIndexer(*indexPath, *workers)
+	idx := indexer.NewIndexer(*indexPath, *workers, indexer.Options{
+		Namespaces: nsIDs,
+		Language:   *lang,
+		Format:     *format,
+		Selection:  selection,
+	})
+	idx.SetMemoryBudget(int64(*memory) << 20)
 
-	err := filepath.Walk(*dataPath, func(path string, info os.FileInfo, err error) error {
+	var files, sqlFiles, pageviewFiles []string
+	err = filepath.Walk(*dataPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if !info.IsDir() && (filepath.Ext(path) == ".xml" || filepath.Ext(path) == ".bz2") {
-			fmt.Printf("Processing file: %s\n", path)
-			return idx.ProcessFile(path)
+		if indexer.IsMultistreamIndex(path) {
+			return nil
+		}
+
+		// a forced format reads everything but hidden files
+		hidden := strings.HasPrefix(info.Name(), ".") && path != *dataPath
+		if info.IsDir() && hidden {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || hidden {
+			return nil
+		}
+		// link tables and pageviews are joined to the pages afterwards
+		if indexer.SQLTable(path) != "" {
+			sqlFiles = append(sqlFiles, path)
+			return nil
+		}
+		if indexer.IsPageviewDump(path) {
+			pageviewFiles = append(pageviewFiles, path)
+			return nil
+		}
+		if *format != "" || indexer.DetectFormat(path) != "" {
+			files = append(files, path)
 		}
 
 		return nil
 	})
 
 	if err != nil {
+		log.Fatal("Error walking data path: ", err)
+	}
+
+	if *resume {
+		if err := idx.Resume(); err != nil {
+			log.Fatal("Failed to resume from checkpoint: ", err)
+		}
+	}
+
+	if *quarantine == "" {
+		*quarantine = filepath.Join(*indexPath, "quarantine.jsonl")
+	}
+	if err := idx.OpenQuarantine(*quarantine); err != nil {
+		log.Fatal("Failed to open quarantine file: ", err)
+	}
+	defer idx.CloseQuarantine()
+
+	if *update {
+		if err := idx.OpenUpdate(); err != nil {
+			log.Fatal("Failed to open index for update: ", err)
+		}
+
+		// an update is small enough to redo, no checkpoints
+		if err := processFiles(idx, files, 0); err != nil {
+			log.Fatal("Error processing files: ", err)
+		}
+		if len(sqlFiles) > 0 || len(pageviewFiles) > 0 {
+			fmt.Println("Skipping sql and pageview dumps, updated pages keep their link and pageview scores")
+		}
+
+		if err := idx.SaveUpdate(*prune); err != nil {
+			log.Fatal("Error saving update: ", err)
+		}
+
+		printSkipped(idx, *quarantine)
+		fmt.Println("Update completed")
+		return
+	}
+
+	if err := processFiles(idx, files, *every); err != nil {
 		log.Fatal("Error processing files: ", err)
 	}
 
+	if len(sqlFiles) > 0 {
+		if err := idx.ImportSQL(sqlFiles); err != nil {
+			log.Fatal("Error importing sql dumps: ", err)
+		}
+	}
+
+	if len(pageviewFiles) > 0 {
+		if err := idx.ImportPageviews(pageviewFiles); err != nil {
+			log.Fatal("Error importing pageviews: ", err)
+		}
+	}
+
 	fmt.Println("Building index...")
 	if err := idx.BuildIndex(); err != nil {
 		log.Fatal("error building index: ", err)
@@ -56,5 +202,91 @@ func main() {
 		log.Fatal("error saving index: ", err)
 	}
 
+	printSkipped(idx, *quarantine)
 	fmt.Println("Indexing completed")
 }
+
+// printSkipped sums up which pages were left out and why
+func printSkipped(idx *indexer.Indexer, quarantine string) {
+	skipped := idx.Skipped()
+
+	var total int64
+	for _, n := range skipped {
+		total += n
+	}
+	fmt.Printf("Skipped %d pages\n", total)
+
+	for _, reason := range indexer.SkipReasons {
+		if n := skipped[reason]; n > 0 {
+			fmt.Printf("  %-12s %d\n", reason, n)
+		}
+	}
+
+	if skipped[indexer.SkipTooShort]+skipped[indexer.SkipMalformed]+skipped[indexer.SkipCleanText] > 0 {
+		fmt.Printf("Titles and offsets of the ones worth a look are in %s\n", quarantine)
+	}
+}
+
+// processFiles runs the parse while checkpointing every so often and on
+// Ctrl-C, so a later -resume run can pick up where this one stopped
+func processFiles(idx *indexer.Indexer, files []string, every time.Duration) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	var tick <-chan time.Time
+	if every > 0 {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- idx.ProcessFiles(files)
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			// parsing is the expensive part, keep it in case building fails
+			if every > 0 {
+				return idx.Checkpoint()
+			}
+			return nil
+
+		case <-tick:
+			if err := idx.Checkpoint(); err != nil {
+				log.Println("Checkpoint failed: ", err)
+			}
+
+		case <-sigs:
+			fmt.Println("Interrupted, checkpointing before exit")
+			if err := idx.Checkpoint(); err != nil {
+				log.Fatal("Checkpoint failed: ", err)
+			}
+			os.Exit(1)
+		}
+	}
+}
+
+func parseIntList(s string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -9,7 +9,9 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Adit0507/wiki-search-engine/internal/models"
 	"github.com/Adit0507/wiki-search-engine/internal/search"
 	"github.com/gorilla/mux"
 )
@@ -23,6 +25,10 @@ func main() {
 	var (
 		indexPath = flag.String("index", "./indexes", "Path to indexes")
 		port      = flag.Int("port", 8080, "Server port")
+		pageRank  = flag.Float64("pagerank-weight", search.DefaultWeights().PageRank, "How much PageRank counts next to text relevance, 0 to ignore it")
+		anchor    = flag.Float64("anchor-weight", search.DefaultWeights().Anchor, "How much incoming link text counts next to the article text, 0 to ignore it")
+		views     = flag.Float64("popularity-weight", search.DefaultWeights().Popularity, "How much log pageviews count next to text relevance, 0 to ignore them")
+		dab       = flag.Float64("disambiguation-penalty", search.DefaultWeights().Disambiguation, "Share of their score disambiguation pages lose, 0 ranks them like articles")
 	)
 	flag.Parse()
 
@@ -31,6 +37,7 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to create search engine: ", err)
 	}
+	engine.SetWeights(search.Weights{PageRank: *pageRank, Anchor: *anchor, Popularity: *views, Disambiguation: *dab})
 
 	tpml, err := template.ParseGlob("web/templates/*.html")
 	if err != nil {
@@ -47,6 +54,7 @@ func main() {
 	r.HandleFunc("/", server.handleHome).Methods("GET")
 	r.HandleFunc("/search", server.handleSearch).Methods("GET")
 	r.HandleFunc("/api/search", server.handleApiSearch).Methods("GET")
+	r.HandleFunc("/api/document", server.handleApiDocument).Methods("GET")
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("web/static/"))))
 
 	fmt.Printf("Server starting on port %d", *port)
@@ -109,12 +117,125 @@ func (s *Server) handleApiSearch(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	results, err := s.engine.Search(query, limit)
+	opts := search.Options{
+		Limit:      limit,
+		Categories: r.URL.Query()["category"],
+	}
+
+	var err error
+	if opts.ModifiedAfter, err = parseTime(r.URL.Query().Get("modified_after")); err != nil {
+		http.Error(w, "Invalid 'modified_after', use YYYY-MM-DD or RFC 3339", http.StatusBadRequest)
+		return
+	}
+	if opts.ModifiedBefore, err = parseTime(r.URL.Query().Get("modified_before")); err != nil {
+		http.Error(w, "Invalid 'modified_before', use YYYY-MM-DD or RFC 3339", http.StatusBadRequest)
+		return
+	}
+
+	switch sort := r.URL.Query().Get("sort"); sort {
+	case "", search.SortRelevance, search.SortModified:
+		opts.Sort = sort
+	default:
+		http.Error(w, "Invalid 'sort', use relevance or modified", http.StatusBadRequest)
+		return
+	}
+
+	if collapse := r.URL.Query().Get("collapse"); collapse != "" {
+		keep, err := strconv.ParseBool(collapse)
+		if err != nil {
+			http.Error(w, "Invalid 'collapse', use true or false", http.StatusBadRequest)
+			return
+		}
+		opts.KeepDuplicates = !keep
+	}
+
+	resp, err := s.engine.SearchWithOptions(query, opts)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Search error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parseTime accepts a plain date or a full timestamp, "" is the zero time
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// the most used labels are enough to see how a page gets linked
+const maxAnchors = 20
+
+type documentResponse struct {
+	DocID       uint32          `json:"doc_id"`
+	PageID      int64           `json:"page_id"`
+	RevisionID  int64           `json:"revision_id"`
+	Title       string          `json:"title"`
+	Aliases     []string        `json:"aliases,omitempty"`
+	Categories  []string        `json:"categories,omitempty"`
+	Infobox     *models.Infobox `json:"infobox,omitempty"`
+	PageRank    float64         `json:"pagerank"`
+	Popularity  int64           `json:"popularity"`
+	Modified    time.Time       `json:"modified,omitzero"`
+	Contributor string          `json:"contributor,omitempty"`
+	Comment     string          `json:"comment,omitempty"`
+	Anchors     []models.Anchor `json:"anchors,omitempty"`
+	Canonical   uint32          `json:"canonical,omitempty"` // doc id of its near-duplicate cluster
+	URL         string          `json:"url"`
+}
+
+// handleApiDocument looks up a single document by either "doc_id" or "page_id"
+func (s *Server) handleApiDocument(w http.ResponseWriter, r *http.Request) {
+	var doc *models.Document
+
+	if pageIDStr := r.URL.Query().Get("page_id"); pageIDStr != "" {
+		pageID, err := strconv.ParseInt(pageIDStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'page_id'", http.StatusBadRequest)
+			return
+		}
+		doc = s.engine.DocumentByPageID(pageID)
+	} else if docIDStr := r.URL.Query().Get("doc_id"); docIDStr != "" {
+		docID, err := strconv.ParseUint(docIDStr, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid 'doc_id'", http.StatusBadRequest)
+			return
+		}
+		doc = s.engine.Document(uint32(docID))
+	} else {
+		http.Error(w, "Query parameter 'doc_id' or 'page_id' is required", http.StatusBadRequest)
+		return
+	}
+
+	if doc == nil {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(documentResponse{
+		DocID:       doc.ID,
+		PageID:      doc.PageID,
+		RevisionID:  doc.RevisionID,
+		Title:       doc.Title,
+		Aliases:     doc.Aliases,
+		Categories:  doc.Categories,
+		Infobox:     doc.Infobox,
+		PageRank:    doc.PageRank,
+		Popularity:  doc.Popularity,
+		Modified:    doc.Modified,
+		Contributor: doc.Contributor,
+		Comment:     doc.Comment,
+		Anchors:     doc.Anchors[:min(len(doc.Anchors), maxAnchors)],
+		Canonical:   doc.Canonical,
+		URL:         doc.URL,
+	})
 }
@@ -3,7 +3,9 @@ module github.com/Adit0507/wiki-search-engine
 go 1.24.5
 
 require (
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/kljensen/snowball v0.10.0 // indirect
-	golang.org/x/text v0.29.0 // indirect
+	github.com/gorilla/mux v1.8.1
+	github.com/klauspost/compress v1.18.0
+	github.com/kljensen/snowball v0.10.0
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/net v0.44.0
 )
@@ -0,0 +1,36 @@
+package indexer
+
+import (
+	"sort"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// anchorRecord is the label of one link into Target, see resolveLinks
+type anchorRecord struct {
+	Target uint32
+	Text   string
+}
+
+func anchorBefore(a, b anchorRecord) bool {
+	if a.Target != b.Target {
+		return a.Target < b.Target
+	}
+	return a.Text < b.Text
+}
+
+// sortAnchors puts the most used labels first
+func sortAnchors(labels map[string]int) []models.Anchor {
+	anchors := make([]models.Anchor, 0, len(labels))
+	for text, count := range labels {
+		anchors = append(anchors, models.Anchor{Text: text, Count: count})
+	}
+	sort.Slice(anchors, func(i, j int) bool {
+		if anchors[i].Count != anchors[j].Count {
+			return anchors[i].Count > anchors[j].Count
+		}
+		return anchors[i].Text < anchors[j].Text
+	})
+
+	return anchors
+}
@@ -0,0 +1,45 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+func TestCollectAnchors(t *testing.T) {
+	idx := NewIndexer(t.TempDir(), 1, Options{})
+	links := map[string][]models.Link{
+		"Paris": {{Target: "Paris", Label: "itself"}, {Target: "France", Label: "France"}},
+		"Lyon":  {{Target: "Paris", Label: "the  capital"}, {Target: "City of Light", Label: "Paris"}, {Target: "Nowhere", Label: "lost"}},
+		"Nice":  {{Target: "paris", Label: "the capital"}, {Target: "Paris", Label: " "}, {Target: "France", Label: "France"}},
+	}
+	var docs []*models.Document
+	for i, title := range []string{"Paris", "France", "Lyon", "Nice"} {
+		doc := models.NewDocument(uint32(i+1), title, articleText("a place in france"), "", idx.build.Language())
+		doc.Links = links[title]
+		docs = append(docs, doc)
+	}
+	flushDocs(t, idx, docs...)
+	idx.build.Redirects.Add("City of Light", "Paris")
+
+	if err := idx.resolveLinks(); err != nil {
+		t.Fatal(err)
+	}
+	saved := savedDocs(t, idx)
+
+	want := map[string][]models.Anchor{
+		"Paris":  {{Text: "the capital", Count: 2}, {Text: "Paris", Count: 1}},
+		"France": {{Text: "France", Count: 2}},
+	}
+	for _, doc := range saved {
+		if !reflect.DeepEqual(doc.Anchors, want[doc.Title]) {
+			t.Errorf("%s anchors = %+v, want %+v", doc.Title, doc.Anchors, want[doc.Title])
+		}
+	}
+
+	paris := saved["Paris"]
+	if paris.AnchorTerms["capit"] != 2 || paris.AnchorLength != 3 {
+		t.Errorf("Paris anchor terms %v length %d, want capit 2 of 3", paris.AnchorTerms, paris.AnchorLength)
+	}
+}
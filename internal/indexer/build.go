@@ -0,0 +1,240 @@
+package indexer
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Adit0507/wiki-search-engine/internal/utils"
+	"github.com/Adit0507/wiki-search-engine/internal/wikitext"
+)
+
+// Options controls which pages end up in the index
+type Options struct {
+	Namespaces []int  // namespace ids to index, main namespace when empty
+	Language   string // wiki language code, detected from the dump when empty
+	Format     string // input format for every file, by extension when empty
+	Selection  Selection
+}
+
+func (o Options) namespaces() []int {
+	if len(o.Namespaces) == 0 {
+		return []int{wikitext.NSMain}
+	}
+
+	return o.Namespaces
+}
+
+func (o Options) indexesNamespace(ns int) bool {
+	return slices.Contains(o.namespaces(), ns)
+}
+
+// Build is the state shared by every parser of one index build
+type Build struct {
+	Options   Options
+	IDs       *IDAllocator
+	Redirects *Redirects
+	Deletions *Deletions
+	progress  *progressTracker
+	skips     *skipLog
+	selector  *selector
+	workers   int // goroutines a single archive may use
+
+	langMutex sync.Mutex
+	language  *utils.Language
+	urlBase   string
+}
+
+func NewBuild(opts Options) *Build {
+	return &Build{
+		Options:   opts,
+		IDs:       &IDAllocator{},
+		Redirects: &Redirects{targets: make(map[string]string)},
+		Deletions: &Deletions{pages: make(map[int64]bool)},
+		progress:  &progressTracker{offsets: make(map[string]int64)},
+		skips:     &skipLog{counts: make(map[string]int64)},
+		selector:  newSelector(opts.Selection),
+		workers:   1,
+	}
+}
+
+// Language is the analyzer every document of the build goes through. the
+// -lang option wins, otherwise the first dump that names a language decides
+func (b *Build) Language() *utils.Language {
+	b.langMutex.Lock()
+	defer b.langMutex.Unlock()
+
+	if b.language == nil {
+		b.language = utils.GetLanguage(b.Options.Language)
+	}
+
+	return b.language
+}
+
+// URLBase is the article url prefix stored in the index metadata
+func (b *Build) URLBase() string {
+	b.langMutex.Lock()
+	defer b.langMutex.Unlock()
+
+	if b.urlBase == "" {
+		return urlBaseFor(b.Options.Language)
+	}
+
+	return b.urlBase
+}
+
+// detectLanguage is called with what each dump's siteinfo says
+func (b *Build) detectLanguage(code, urlBase string) {
+	b.langMutex.Lock()
+	defer b.langMutex.Unlock()
+
+	if b.Options.Language != "" || code == "" {
+		return
+	}
+
+	if b.language == nil {
+		b.language = utils.GetLanguage(code)
+		b.urlBase = urlBase
+		return
+	}
+
+	if b.language.Code != code {
+		fmt.Printf("Warning: dump language %q differs from index language %q\n", code, b.language.Code)
+	}
+}
+
+func urlBaseFor(lang string) string {
+	if lang == "" {
+		lang = "en"
+	}
+
+	return fmt.Sprintf("https://%s.wikipedia.org/wiki/", lang)
+}
+
+// IDAllocator hands out document ids that are unique across every file
+// of a build, no matter how many parsers run at once
+type IDAllocator struct {
+	next atomic.Uint32
+}
+
+func (a *IDAllocator) Next() uint32 {
+	return a.next.Add(1)
+}
+
+// Redirects collects redirect title -> target title from every parser
+type Redirects struct {
+	mutex   sync.Mutex
+	targets map[string]string
+}
+
+func (r *Redirects) Add(from, to string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.targets[from] = to
+}
+
+// Map returns a copy of everything collected so far
+func (r *Redirects) Map() map[string]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	targets := make(map[string]string, len(r.targets))
+	for from, to := range r.targets {
+		targets[from] = to
+	}
+
+	return targets
+}
+
+// Deletions collects the page ids a changeset removes from the index
+type Deletions struct {
+	mutex sync.Mutex
+	pages map[int64]bool
+}
+
+func (d *Deletions) Add(pageID int64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.pages[pageID] = true
+}
+
+func (d *Deletions) Pages() []int64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	pages := make([]int64, 0, len(d.pages))
+	for pageID := range d.pages {
+		pages = append(pages, pageID)
+	}
+
+	return pages
+}
+
+// streamDone marks a stream that was parsed to the end
+const streamDone int64 = -1
+
+// progressTracker remembers how far into each input stream the build got.
+// parsers hold the gate while they handle a page and count every document
+// they send, so pause can wait for a point where the index matches the
+// recorded offsets exactly
+type progressTracker struct {
+	gate    sync.RWMutex
+	pending sync.WaitGroup
+	mutex   sync.Mutex
+	offsets map[string]int64
+}
+
+// offset returns where a stream should pick up, 0 for the start
+func (t *progressTracker) offset(key string) int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.offsets[key]
+}
+
+func (t *progressTracker) begin() {
+	t.gate.RLock()
+}
+
+// end records offset for the stream, an empty key records nothing
+func (t *progressTracker) end(key string, offset int64) {
+	if key != "" {
+		t.mutex.Lock()
+		t.offsets[key] = offset
+		t.mutex.Unlock()
+	}
+
+	t.gate.RUnlock()
+}
+
+func (t *progressTracker) sent() {
+	t.pending.Add(1)
+}
+
+func (t *progressTracker) added() {
+	t.pending.Done()
+}
+
+// pause blocks new pages, waits for every sent document to be added and
+// returns a copy of the offsets
+func (t *progressTracker) pause() map[string]int64 {
+	t.gate.Lock()
+	t.pending.Wait()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	offsets := make(map[string]int64, len(t.offsets))
+	for key, offset := range t.offsets {
+		offsets[key] = offset
+	}
+
+	return offsets
+}
+
+func (t *progressTracker) unpause() {
+	t.gate.Unlock()
+}
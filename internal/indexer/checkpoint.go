@@ -0,0 +1,85 @@
+package indexer
+
+import (
+	"fmt"
+
+	"github.com/Adit0507/wiki-search-engine/internal/storage"
+)
+
+// Checkpoint saves the partial index together with how far every input
+// stream got. it's safe to call while ProcessFiles is running: parsers are
+// paused between pages until the snapshot is written
+func (idx *Indexer) Checkpoint() error {
+	progress := idx.build.progress.pause()
+	defer idx.build.progress.unpause()
+
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	fmt.Printf("Checkpointing %d documents...\n", idx.docCount)
+
+	return idx.storage.SaveCheckpoint(&storage.Checkpoint{
+		Documents:   idx.documents,
+		TermIndex:   idx.termIndex,
+		Redirects:   idx.build.Redirects.Map(),
+		PageIDs:     idx.pageIDs,
+		NextID:      idx.build.IDs.next.Load(),
+		Progress:    progress,
+		Skipped:     idx.build.skipped(),
+		Quarantined: idx.build.quarantined(),
+		Segments:    idx.segments,
+		DocCount:    idx.docCount,
+		Language:    idx.build.Language().Code,
+		URLBase:     idx.build.URLBase(),
+	})
+}
+
+// Resume loads the last checkpoint, after which ProcessFiles skips whatever
+// the checkpoint already covers
+func (idx *Indexer) Resume() error {
+	cp, err := idx.storage.LoadCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.documents = cp.Documents
+	idx.termIndex = cp.TermIndex
+	idx.pageIDs = cp.PageIDs
+	idx.docCount = cp.DocCount
+	// checkpoints from before segments only counted what they held
+	if cp.Segments == 0 {
+		idx.docCount = len(cp.Documents)
+	}
+	if err := idx.resumeSegments(cp.Segments); err != nil {
+		return err
+	}
+
+	for from, to := range cp.Redirects {
+		idx.build.Redirects.Add(from, to)
+	}
+	idx.build.IDs.next.Store(cp.NextID)
+	// -max-docs counts the checkpointed documents too
+	idx.build.selector.docs.Store(int64(idx.docCount))
+	idx.build.detectLanguage(cp.Language, cp.URLBase)
+
+	// the analyzer isn't saved, aliases and anchors added later need it
+	lang := idx.build.Language()
+	for _, doc := range idx.documents {
+		doc.SetLanguage(lang)
+	}
+
+	for key, offset := range cp.Progress {
+		idx.build.progress.offsets[key] = offset
+	}
+	for reason, n := range cp.Skipped {
+		idx.build.skips.counts[reason] = n
+	}
+	idx.quarantined = cp.Quarantined
+	idx.resumed = true
+
+	fmt.Printf("Resumed from checkpoint with %d documents\n", idx.docCount)
+	return nil
+}
@@ -0,0 +1,149 @@
+package indexer
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/storage"
+)
+
+// savedIndex is everything a build leaves in the index directory
+type savedIndex struct {
+	Documents map[uint32]*models.Document
+	Terms     map[string][]uint32
+	PageIDs   map[int64]uint32
+	Lengths   map[uint32]int
+	Titles    map[string]uint32
+	Metadata  map[string]interface{}
+}
+
+func loadSaved(t *testing.T, dir string) savedIndex {
+	t.Helper()
+
+	var saved savedIndex
+	var err error
+	ds := storage.NewDiskStorage(dir)
+	if saved.Documents, err = ds.LoadDocuments(); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Terms, err = ds.LoadTermIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if saved.PageIDs, err = ds.LoadIDMap(); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Lengths, err = ds.LoadLengths(); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Titles, err = ds.LoadTitles(); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Metadata, err = readMetadata(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	return saved
+}
+
+// compareSaved reports the first documents and terms that differ, a
+// DeepEqual of the whole index says little about where
+func compareSaved(t *testing.T, got, want savedIndex) {
+	t.Helper()
+
+	for id, doc := range want.Documents {
+		if other := got.Documents[id]; !reflect.DeepEqual(other, doc) {
+			if other == nil || other.Title != doc.Title {
+				t.Errorf("document %d is %s, want %s", id, describe(other), describe(doc))
+			} else {
+				t.Errorf("document %d = %+v, want %+v", id, other, doc)
+			}
+			break
+		}
+	}
+	for term, docs := range want.Terms {
+		if !reflect.DeepEqual(got.Terms[term], docs) {
+			t.Errorf("postings of %q = %v, want %v", term, got.Terms[term], docs)
+			break
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("saved index differs, %d documents and %d terms, want %d and %d",
+			len(got.Documents), len(got.Terms), len(want.Documents), len(want.Terms))
+	}
+}
+
+func describe(doc *models.Document) string {
+	if doc == nil {
+		return "missing"
+	}
+	return fmt.Sprintf("%q page %d", doc.Title, doc.PageID)
+}
+
+// cityDumps writes a few dumps of pages linking to each other, with
+// redirects and a page that's in two of them
+func cityDumps(t *testing.T) []string {
+	t.Helper()
+
+	sights := []string{"cathedral", "harbour", "castle", "museum", "bridge", "market", "tower", "vineyard", "abbey", "canal", "fortress"}
+
+	var files []string
+	for f := 0; f < 3; f++ {
+		var pages []string
+		for i := 0; i < 8; i++ {
+			id := int64(f*100 + i + 1)
+			text := articleText(fmt.Sprintf("city %d of region %d with its %s and %s", i, f, sights[(f+i)%11], sights[(3*f+i+5)%11]),
+				fmt.Sprintf("[[City %d-%d]] [[City %d-%d|the next one]]", f, (i+1)%8, (f+1)%3, i))
+			pages = append(pages, page(fmt.Sprintf("City %d-%d", f, i), 0, id, text))
+		}
+		pages = append(pages, redirectPage(fmt.Sprintf("Capital %d", f), int64(f*100+50), fmt.Sprintf("City %d-0", f)))
+		pages = append(pages, page("Shared", 0, 999, articleText(fmt.Sprintf("a page in dump %d", f))))
+
+		files = append(files, writeDump(t, fmt.Sprintf("dump-%d.xml", f), xmlDump(pages...)))
+	}
+
+	return files
+}
+
+// a build that's checkpointed partway and resumed saves the same index as
+// one that ran through, with and without segments
+func TestResumeMatchesUninterrupted(t *testing.T) {
+	files := cityDumps(t)
+
+	for _, budget := range []int64{0, 20000} {
+		t.Run(fmt.Sprintf("budget %d", budget), func(t *testing.T) {
+			want := loadSaved(t, buildTestIndex(t, budget, files...))
+
+			for _, stop := range []int{1, 9, 17} {
+				dir := t.TempDir()
+
+				interrupted := NewIndexer(dir, 2, Options{Selection: Selection{MaxDocs: stop}})
+				interrupted.SetMemoryBudget(budget)
+				if err := interrupted.ProcessFiles(files); err != nil {
+					t.Fatal(err)
+				}
+				if err := interrupted.Checkpoint(); err != nil {
+					t.Fatal(err)
+				}
+
+				resumed := NewIndexer(dir, 2, Options{})
+				resumed.SetMemoryBudget(budget)
+				if err := resumed.Resume(); err != nil {
+					t.Fatal(err)
+				}
+				if err := resumed.ProcessFiles(files); err != nil {
+					t.Fatal(err)
+				}
+				if err := resumed.BuildIndex(); err != nil {
+					t.Fatal(err)
+				}
+				if err := resumed.SaveToDisk(); err != nil {
+					t.Fatal(err)
+				}
+
+				compareSaved(t, loadSaved(t, dir), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package indexer
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/wikitext"
+)
+
+var (
+	// {{disambiguation}} and the templates that stand in for it on the
+	// wikis we have analyzers for, lowercase
+	disambiguationTemplates = map[string]bool{
+		"disambiguation": true, "disambig": true, "dab": true, "disamb": true,
+		"hndis": true, "geodis": true, "numberdis": true, "schooldis": true,
+		"mil-unit-dis": true, "letter-numbercombdisambig": true,
+		"begriffsklärung": true, "homonymie": true, "desambiguación": true,
+		"неоднозначность": true, "förgrening": true, "peker": true, "egyért": true,
+	}
+
+	// "Mercury (disambiguation)", for dumps without the templates
+	disambiguationTitleRe = regexp.MustCompile(`(?i)\s\((disambiguation|begriffsklärung|homonymie|desambiguación|неоднозначность)\)$`)
+)
+
+// isDisambiguation looks for a disambiguation template or the magic word
+// most of them expand to
+func isDisambiguation(parsed *wikitext.Page, text string) bool {
+	for _, t := range parsed.Templates {
+		name := strings.ToLower(t.Name)
+		if disambiguationTemplates[name] || strings.HasSuffix(name, " disambiguation") {
+			return true
+		}
+	}
+
+	return strings.Contains(text, "__DISAMBIG__")
+}
+
+// markDisambiguation flags the document and keeps the articles it links to
+// as the candidates it lists. flagged is what the page markup said
+func markDisambiguation(doc *models.Document, flagged bool) {
+	if !flagged && !disambiguationTitleRe.MatchString(doc.Title) {
+		return
+	}
+
+	doc.Disambiguation = true
+	seen := make(map[string]bool)
+	for _, link := range doc.Links {
+		key := models.TitleKey(link.Target)
+		if !seen[key] && key != models.TitleKey(doc.Title) {
+			seen[key] = true
+			doc.Candidates = append(doc.Candidates, link.Target)
+		}
+	}
+}
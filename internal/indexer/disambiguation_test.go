@@ -0,0 +1,38 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDisambiguation(t *testing.T) {
+	links := "[[Mercury (planet)|the planet]], [[Mercury (element)]] or [[mercury (planet)]], see also [[Mercury]] "
+
+	tests := []struct {
+		title      string
+		text       string
+		want       bool
+		candidates []string
+	}{
+		{"Mercury", links + "{{disambiguation}}", true, []string{"Mercury (planet)", "Mercury (element)"}},
+		{"Mercury", links + "{{Disambig|geo}}", true, []string{"Mercury (planet)", "Mercury (element)"}},
+		{"Mercury", links + "{{Place name disambiguation}}", true, []string{"Mercury (planet)", "Mercury (element)"}},
+		{"Mercury", links + "__DISAMBIG__", true, []string{"Mercury (planet)", "Mercury (element)"}},
+		{"Mercury (disambiguation)", links, true, []string{"Mercury (planet)", "Mercury (element)", "Mercury"}},
+		{"Merkur (Begriffsklärung)", links, true, []string{"Mercury (planet)", "Mercury (element)", "Mercury"}},
+		{"Mercury", links + "{{Infobox planet}}", false, nil},
+		{"Mercury (planet)", links, false, nil},
+	}
+
+	for _, tt := range tests {
+		docs := parseDump(t, NewBuild(Options{}), "<mediawiki>"+siteInfo+page(tt.title, 0, 1, articleText(tt.text))+"</mediawiki>")
+		if len(docs) != 1 {
+			t.Fatalf("%s %q: indexed %d pages, want 1", tt.title, tt.text, len(docs))
+		}
+
+		doc := docs[0]
+		if doc.Disambiguation != tt.want || !reflect.DeepEqual(doc.Candidates, tt.candidates) {
+			t.Errorf("%s %q: disambiguation %v candidates %q, want %v %q", tt.title, tt.text, doc.Disambiguation, doc.Candidates, tt.want, tt.candidates)
+		}
+	}
+}
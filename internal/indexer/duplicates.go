@@ -0,0 +1,284 @@
+package indexer
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// hashes split into this many bands, two hashes within SimHashDistance bits
+// of each other agree on at least one band, so only docs sharing a band
+// need comparing
+const simHashBands = models.SimHashDistance + 1
+
+// bandRecord is one band of a document's simhash, Key holds the band's
+// number above its bits so every band sorts apart
+type bandRecord struct {
+	Key  uint64
+	Hash uint64
+	ID   uint32
+}
+
+func bandBefore(a, b bandRecord) bool {
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	if a.Hash != b.Hash {
+		return a.Hash < b.Hash
+	}
+	return a.ID < b.ID
+}
+
+// memberRecord is a document with a simhash and what decides whether it
+// stands for its cluster, Root is the cluster once it's known
+type memberRecord struct {
+	Root     uint32
+	ID       uint32
+	PageRank float64
+	Length   int
+}
+
+func memberBefore(a, b memberRecord) bool {
+	if a.Root != b.Root {
+		return a.Root < b.Root
+	}
+	return canonicalBefore(a, b)
+}
+
+// clusterRecord points the document ID at its cluster's canonical document
+type clusterRecord struct {
+	ID        uint32
+	Canonical uint32
+}
+
+func clusterBefore(a, b clusterRecord) bool {
+	return a.ID < b.ID
+}
+
+// clusterDuplicates groups documents whose simhashes are near each other
+// and points every member at the cluster's canonical document. the docs
+// sharing a band are spilled together, only the union-find over doc ids
+// stays in memory
+func (idx *Indexer) clusterDuplicates() error {
+	tables, err := idx.lookups()
+	if err != nil {
+		return err
+	}
+
+	bands := &spill[bandRecord]{
+		dir:    idx.segmentDir(),
+		name:   "bands",
+		less:   bandBefore,
+		size:   func(bandRecord) int64 { return 24 },
+		budget: idx.budget,
+	}
+	membersPath := filepath.Join(idx.segmentDir(), "members.gob")
+	members, err := createRun[memberRecord](membersPath)
+	if err != nil {
+		return err
+	}
+
+	// the canonical document is picked by the final length and pagerank,
+	// aliases and all
+	results, err := idx.openResults()
+	if err != nil {
+		members.file.Close()
+		return err
+	}
+	width := 64 / simHashBands
+	err = idx.eachDocument(func(doc *models.Document) error {
+		if doc.SimHash == 0 {
+			return nil
+		}
+		if err := results.addAliases(doc, func(string) error { return nil }); err != nil {
+			return err
+		}
+		if err := results.setPageRank(doc); err != nil {
+			return err
+		}
+
+		for band := 0; band < simHashBands; band++ {
+			key := uint64(band)<<width | doc.SimHash>>(band*width)&(1<<width-1)
+			if err := bands.add(bandRecord{Key: key, Hash: doc.SimHash, ID: doc.ID}); err != nil {
+				return err
+			}
+		}
+		return members.write(memberRecord{ID: doc.ID, PageRank: doc.PageRank, Length: doc.Length})
+	})
+	results.close()
+	if err == nil {
+		err = bands.flush()
+	}
+	if err != nil {
+		members.file.Close()
+		return err
+	}
+	if err := members.close(); err != nil {
+		return err
+	}
+
+	parent := make([]uint32, tables.maxID+1)
+	for i := range parent {
+		parent[i] = uint32(i)
+	}
+	find := func(id uint32) uint32 {
+		root := id
+		for parent[root] != root {
+			root = parent[root]
+		}
+		for parent[id] != root {
+			parent[id], id = root, parent[id]
+		}
+		return root
+	}
+	union := func(a, b uint32) {
+		parent[find(a)] = find(b)
+	}
+
+	if err := unionBands(bands.paths, union); err != nil {
+		return err
+	}
+
+	clusters := &spill[memberRecord]{
+		dir:    idx.segmentDir(),
+		name:   "clusters",
+		less:   memberBefore,
+		size:   func(memberRecord) int64 { return 32 },
+		budget: idx.budget,
+	}
+	err = eachValue(membersPath, func(m memberRecord) error {
+		m.Root = find(m.ID)
+		return clusters.add(m)
+	})
+	if err == nil {
+		err = clusters.flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	canonical := &spill[clusterRecord]{
+		dir:    idx.segmentDir(),
+		name:   "canonical",
+		less:   clusterBefore,
+		size:   func(clusterRecord) int64 { return 8 },
+		budget: idx.budget,
+	}
+	duplicates, err := pickCanonical(clusters.paths, canonical)
+	if err == nil {
+		err = canonical.flush()
+	}
+	if err == nil {
+		err = removeRuns(append(append(bands.paths, clusters.paths...), membersPath))
+	}
+	idx.clusterRuns = canonical.paths
+
+	fmt.Printf("Found %d near-duplicate documents\n", duplicates)
+	return err
+}
+
+// unionBands joins the documents of every band bucket whose hashes are
+// near each other. identical hashes are one comparison, whatever their
+// number
+func unionBands(paths []string, union func(a, b uint32)) error {
+	bands, err := mergeRuns(paths, bandBefore)
+	if err != nil {
+		return err
+	}
+	defer bands.close()
+
+	var bucket []bandRecord // one document per distinct hash
+	compare := func() {
+		for i, a := range bucket {
+			for _, b := range bucket[i+1:] {
+				if models.NearDuplicate(a.Hash, b.Hash) {
+					union(a.ID, b.ID)
+				}
+			}
+		}
+		bucket = bucket[:0]
+	}
+
+	for {
+		band, ok, err := bands.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		if len(bucket) > 0 {
+			last := bucket[len(bucket)-1]
+			if last.Key != band.Key {
+				compare()
+			} else if last.Hash == band.Hash {
+				union(band.ID, last.ID)
+				continue
+			}
+		}
+		bucket = append(bucket, band)
+	}
+	compare()
+
+	return nil
+}
+
+// pickCanonical reads the members cluster by cluster, the canonical one
+// first, and points every member of a cluster of two or more at it. it
+// returns how many duplicates that made
+func pickCanonical(paths []string, canonical *spill[clusterRecord]) (int, error) {
+	clusters, err := mergeRuns(paths, memberBefore)
+	if err != nil {
+		return 0, err
+	}
+	defer clusters.close()
+
+	duplicates := 0
+	var cluster []memberRecord
+	flush := func() error {
+		if len(cluster) > 1 {
+			duplicates += len(cluster) - 1
+			for _, m := range cluster {
+				if err := canonical.add(clusterRecord{ID: m.ID, Canonical: cluster[0].ID}); err != nil {
+					return err
+				}
+			}
+		}
+		cluster = cluster[:0]
+		return nil
+	}
+
+	for {
+		m, ok, err := clusters.next()
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+
+		if len(cluster) > 0 && cluster[0].Root != m.Root {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+		cluster = append(cluster, m)
+	}
+
+	return duplicates, flush()
+}
+
+// canonicalBefore picks the document that stands for a cluster, the best
+// linked one, then the longest, then the oldest id
+func canonicalBefore(a, b memberRecord) bool {
+	if a.PageRank != b.PageRank {
+		return a.PageRank > b.PageRank
+	}
+	if a.Length != b.Length {
+		return a.Length > b.Length
+	}
+
+	return a.ID < b.ID
+}
@@ -0,0 +1,63 @@
+package indexer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// stub is long enough that a couple of changed words move few bits
+var stub = func() string {
+	words := strings.Fields("is a small village in the administrative district of gmina within county in the voivodeship of southern poland")
+	for i := 0; i < 150; i++ {
+		words = append(words, fmt.Sprintf("detail%d", i))
+	}
+	return strings.Join(words, " ")
+}()
+
+func TestClusterDuplicates(t *testing.T) {
+	texts := map[string]string{
+		"Zabierzów":           "zabierzów " + stub,
+		"Zabierzów Bocheński": "zabierzów bocheński " + stub,
+		"Zabierzów village":   "zabierzów " + stub + " in total",
+		"Kraków":              "kraków is the second largest and one of the oldest cities in poland, on the vistula river, with a long history of culture and education",
+		"Short":               "a village",
+		"Short copy":          "a village",
+	}
+	pageRanks := map[string]float64{"Zabierzów Bocheński": 2}
+
+	idx := NewIndexer(t.TempDir(), 1, Options{})
+	id := uint32(0)
+	var docs []*models.Document
+	for _, title := range []string{"Zabierzów", "Zabierzów Bocheński", "Zabierzów village", "Kraków", "Short", "Short copy"} {
+		id++
+		doc := models.NewDocument(id, title, texts[title], "", idx.build.Language())
+		doc.PageRank = pageRanks[title]
+		docs = append(docs, doc)
+	}
+	flushDocs(t, idx, docs...)
+
+	if err := idx.clusterDuplicates(); err != nil {
+		t.Fatal(err)
+	}
+	saved := savedDocs(t, idx)
+
+	canonical := map[string]string{
+		"Zabierzów":           "Zabierzów Bocheński",
+		"Zabierzów Bocheński": "Zabierzów Bocheński", // best linked
+		"Zabierzów village":   "Zabierzów Bocheński",
+	}
+	for _, doc := range saved {
+		want := uint32(0)
+		for _, other := range saved {
+			if other.Title == canonical[doc.Title] {
+				want = other.ID
+			}
+		}
+		if doc.Canonical != want {
+			t.Errorf("%s canonical %d, want %d (%q)", doc.Title, doc.Canonical, want, canonical[doc.Title])
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package indexer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"golang.org/x/net/html"
+)
+
+// EnterpriseArticle is one line of a wikimedia enterprise html dump, only
+// the parts we index
+type EnterpriseArticle struct {
+	Name         string    `json:"name"`
+	Identifier   int64     `json:"identifier"`
+	DateModified time.Time `json:"date_modified"`
+	URL          string    `json:"url"`
+	Namespace    struct {
+		Identifier int `json:"identifier"`
+	} `json:"namespace"`
+	InLanguage struct {
+		Identifier string `json:"identifier"`
+	} `json:"in_language"`
+	Version struct {
+		Identifier int64  `json:"identifier"`
+		Comment    string `json:"comment"`
+		Editor     struct {
+			Name string `json:"name"`
+		} `json:"editor"`
+	} `json:"version"`
+	ArticleBody struct {
+		HTML string `json:"html"`
+	} `json:"article_body"`
+	Categories []struct {
+		Name string `json:"name"`
+	} `json:"categories"`
+	Redirects []struct {
+		Name string `json:"name"`
+	} `json:"redirects"`
+}
+
+// EnterpriseReader reads the ndjson files of a wikimedia enterprise html
+// dump, either loose or still inside the .tar.gz they're published as
+type EnterpriseReader struct {
+	docChan chan<- *models.Document
+	build   *Build
+}
+
+func (r *EnterpriseReader) ParseFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if !isTarball(filename) {
+		return r.build.parseLines(file, filename, func(line []byte, offset int64) bool {
+			return r.handleLine(line, filename, offset)
+		})
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(header.Name, ".ndjson") {
+			continue
+		}
+
+		key := fmt.Sprintf("%s@%s", filename, header.Name)
+		err = r.build.parseLines(archive, key, func(line []byte, offset int64) bool {
+			return r.handleLine(line, key, offset)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+	}
+}
+
+func isTarball(filename string) bool {
+	lower := strings.ToLower(filename)
+	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// handleLine is false when -max-docs refused the article
+func (r *EnterpriseReader) handleLine(line []byte, key string, offset int64) bool {
+	var article EnterpriseArticle
+	if err := json.Unmarshal(line, &article); err != nil {
+		r.build.skip(Skip{Reason: SkipMalformed, File: key, Offset: offset, Error: err.Error()})
+		return true
+	}
+
+	skip := Skip{File: key, Offset: offset, Title: article.Name, PageID: article.Identifier}
+	if !r.build.Options.indexesNamespace(article.Namespace.Identifier) || article.Name == "" {
+		skip.Reason = SkipNamespace
+		r.build.skip(skip)
+		return true
+	}
+	if ok, full := r.build.admit(skip); !ok {
+		return !full
+	}
+
+	// the dump has no siteinfo, every article names its language
+	urlBase := ""
+	if i := strings.Index(article.URL, "/wiki/"); i >= 0 {
+		urlBase = article.URL[:i+len("/wiki/")]
+	}
+	r.build.detectLanguage(article.InLanguage.Identifier, urlBase)
+
+	for _, redirect := range article.Redirects {
+		if redirect.Name != "" {
+			r.build.Redirects.Add(redirect.Name, article.Name)
+		}
+	}
+
+	if doc := r.createDocument(&article); doc != nil {
+		r.build.send(r.docChan, doc, key, offset)
+	} else {
+		r.build.release()
+		skip.Reason = SkipCleanText
+		r.build.skip(skip)
+	}
+
+	return true
+}
+
+func (r *EnterpriseReader) createDocument(article *EnterpriseArticle) *models.Document {
+	root, err := html.Parse(strings.NewReader(article.ArticleBody.HTML))
+	if err != nil {
+		return nil
+	}
+
+	page := parseHTML(root)
+	if len(page.content) < 50 {
+		return nil
+	}
+
+	url := article.URL
+	if url == "" {
+		url = r.build.URLBase() + strings.ReplaceAll(article.Name, " ", "_")
+	}
+
+	doc := models.NewDocument(r.build.IDs.Next(), article.Name, page.content, url, r.build.Language())
+	doc.PageID = article.Identifier
+	doc.RevisionID = article.Version.Identifier
+	doc.Modified = article.DateModified
+	doc.Contributor = article.Version.Editor.Name
+	doc.Comment = article.Version.Comment
+	doc.Sections = page.sections
+
+	// prefer the json list when the dump has one
+	doc.Categories = page.categories
+	if len(article.Categories) > 0 {
+		doc.Categories = nil
+		for _, category := range article.Categories {
+			if _, name, found := strings.Cut(category.Name, ":"); found {
+				doc.Categories = append(doc.Categories, name)
+			}
+		}
+	}
+
+	for _, link := range page.links {
+		if target := relativeLinkTitle(link.Href); target != "" {
+			doc.Links = append(doc.Links, models.Link{Target: target, Label: link.Label})
+		}
+	}
+	markDisambiguation(doc, page.disambiguation)
+
+	return doc
+}
@@ -0,0 +1,274 @@
+package indexer
+
+import (
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/wikitext"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// page furniture, never article text
+	htmlSkip = map[atom.Atom]bool{
+		atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Nav: true,
+		atom.Header: true, atom.Footer: true, atom.Aside: true, atom.Template: true,
+		atom.Svg: true, atom.Form: true, atom.Button: true, atom.Iframe: true,
+	}
+
+	// wiki page furniture that mediawiki and kiwix html share
+	htmlSkipClasses = []string{
+		"mw-editsection", "mw-ref", "reference", "noprint", "navbox",
+		"mw-references-wrap", "mw-empty-elt",
+	}
+
+	htmlHeadings = map[atom.Atom]int{
+		atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+	}
+
+	// elements that end a paragraph, anything else flows inline
+	htmlBlocks = map[atom.Atom]bool{
+		atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+		atom.Main: true, atom.Li: true, atom.Ul: true, atom.Ol: true, atom.Dl: true,
+		atom.Dt: true, atom.Dd: true, atom.Table: true, atom.Tr: true, atom.Td: true,
+		atom.Th: true, atom.Blockquote: true, atom.Pre: true, atom.Br: true,
+		atom.Hr: true, atom.Figure: true, atom.Figcaption: true, atom.Caption: true,
+	}
+)
+
+// HTMLReader reads a saved html page as one document. the <title> (or
+// first <h1>) names it, headings split it into sections
+type HTMLReader struct {
+	docChan chan<- *models.Document
+	build   *Build
+}
+
+func (r *HTMLReader) ParseFile(filename string) error {
+	return r.build.parseWhole(filename, func() error {
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		root, err := html.Parse(file)
+		if err != nil {
+			return err
+		}
+
+		page := parseHTML(root)
+		if page.title == "" {
+			page.title = titleFromFilename(filename)
+		}
+		if len(page.content) < 50 {
+			r.build.skip(Skip{Reason: SkipCleanText, File: filename, Title: page.title})
+			return nil
+		}
+		if ok, full := r.build.admit(Skip{File: filename, Title: page.title}); !ok {
+			return refused(full)
+		}
+
+		url := page.canonical
+		if url == "" {
+			url = fileURL(filename)
+		}
+
+		doc := models.NewDocument(r.build.IDs.Next(), page.title, page.content, url, r.build.Language())
+		doc.Sections = page.sections
+		if info, err := file.Stat(); err == nil {
+			doc.Modified = info.ModTime().UTC()
+		}
+
+		r.build.send(r.docChan, doc, filename, 0)
+		return nil
+	})
+}
+
+type htmlPage struct {
+	title      string
+	canonical  string
+	content    string
+	sections   []models.Section
+	links      []htmlLink
+	categories []string // from mediawiki's category <link>s
+
+	// parsoid marks disambiguation pages with a page property
+	disambiguation bool
+}
+
+// htmlLink is an <a> as written, readers that know the site's url layout
+// turn Href into an article title
+type htmlLink struct {
+	Href  string
+	Label string
+}
+
+func parseHTML(root *html.Node) htmlPage {
+	var (
+		page htmlPage
+		tb   textBuilder
+		para strings.Builder
+		h1   string
+	)
+
+	flush := func() {
+		tb.paragraph(para.String())
+		para.Reset()
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			para.WriteString(n.Data)
+			return
+
+		case html.ElementNode:
+			if htmlSkip[n.DataAtom] || skipClass(n) {
+				return
+			}
+
+			switch n.DataAtom {
+			case atom.Title:
+				if page.title == "" {
+					page.title = strings.Join(strings.Fields(nodeText(n)), " ")
+				}
+				return
+
+			case atom.Link:
+				switch attr(n, "rel") {
+				case "canonical":
+					page.canonical = attr(n, "href")
+				case "mw:PageProp/Category":
+					// ./Category:Capitals_in_Europe#sortkey
+					if category := relativeLinkTitle(attr(n, "href")); category != "" {
+						if _, name, found := strings.Cut(category, ":"); found {
+							page.categories = append(page.categories, name)
+						}
+					}
+				}
+				return
+
+			case atom.Meta:
+				if attr(n, "property") == "mw:PageProp/disambiguation" {
+					page.disambiguation = true
+				}
+				return
+
+			case atom.A:
+				if href := attr(n, "href"); href != "" {
+					page.links = append(page.links, htmlLink{
+						Href:  href,
+						Label: strings.Join(strings.Fields(nodeText(n)), " "),
+					})
+				}
+			}
+
+			if level, ok := htmlHeadings[n.DataAtom]; ok {
+				flush()
+				text := strings.Join(strings.Fields(nodeText(n)), " ")
+				if level == 1 && h1 == "" {
+					h1 = text
+					// the page heading repeating the title isn't a section
+					if page.title == "" || strings.EqualFold(text, page.title) {
+						return
+					}
+				}
+				tb.heading(text, level)
+				return
+			}
+
+			if htmlBlocks[n.DataAtom] {
+				flush()
+				defer flush()
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	flush()
+
+	if page.title == "" {
+		page.title = h1
+	}
+	page.content, page.sections = tb.finish()
+
+	return page
+}
+
+// nodeText is all the text under n
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (htmlSkip[c.DataAtom] || skipClass(c)) {
+			continue
+		}
+		b.WriteString(nodeText(c))
+		b.WriteByte(' ')
+	}
+
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+func skipClass(n *html.Node) bool {
+	class := attr(n, "class")
+	if class == "" {
+		return false
+	}
+
+	for _, name := range strings.Fields(class) {
+		if slices.Contains(htmlSkipClasses, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// relativeLinkTitle turns a relative article href like
+// "./Eiffel_Tower#History" into a title, "" for anything that leaves the site
+func relativeLinkTitle(href string) string {
+	if strings.Contains(href, "://") || strings.HasPrefix(href, "/") || strings.HasPrefix(href, "#") {
+		return ""
+	}
+
+	href, _, _ = strings.Cut(href, "#")
+	href, _, _ = strings.Cut(href, "?")
+	for {
+		trimmed := strings.TrimPrefix(strings.TrimPrefix(href, "./"), "../")
+		if trimmed == href {
+			break
+		}
+		href = trimmed
+	}
+	// old kiwix archives keep articles under A/
+	href = strings.TrimPrefix(href, "A/")
+
+	title, err := url.PathUnescape(href)
+	if err != nil {
+		return ""
+	}
+
+	return wikitext.NormalizeTitle(title)
+}
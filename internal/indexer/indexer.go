@@ -1,10 +1,8 @@
 package indexer
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 	"sync"
 
 	"github.com/Adit0507/wiki-search-engine/internal/models"
@@ -14,25 +12,65 @@ import (
 type Indexer struct {
 	indexPath string
 	workers   int
+	build     *Build
 	documents map[uint32]*models.Document
 	termIndex map[string][]uint32
+	pageIDs   map[int64]uint32
 	docCount  int
 	avgDocLen float64
 	storage   *storage.DiskStorage
 	mutex     sync.RWMutex
+	resumed   bool
+
+	// quarantine file size at the checkpoint a resumed build started from
+	quarantined int64
+
+	// the saved index an update applies to, see OpenUpdate
+	base *baseIndex
+
+	// segmented builds, see SetMemoryBudget
+	budget    int64         // bytes held before a flush, 0 flushes once parsing is done
+	memory    int64         // held by the documents in memory
+	segments  int           // flushed so far
+	flushErr  error         // first failed flush, the rest stays in memory
+	tables    *lookupTables // see lookups
+	termCount int           // terms after mergeSegments
+
+	// sorted runs of what the stages after parsing found, mergeSegments
+	// applies them to the documents
+	linkRuns     []string // pagelinks rows, see importPageLinks
+	textLinkRuns []string // links of the text, see resolveLinks
+	anchorRuns   []string
+	aliasRuns    []string
+	categoryRuns []string
+	viewRuns     []string
+	rankRuns     []string
+	clusterRuns  []string
 }
 
-func NewIndexer(indexPath string, workers int) *Indexer {
+func NewIndexer(indexPath string, workers int, opts Options) *Indexer {
+	build := NewBuild(opts)
+	build.workers = max(workers, 1)
+
 	return &Indexer{
 		indexPath: indexPath,
 		workers:   workers,
+		build:     build,
 		documents: make(map[uint32]*models.Document),
 		termIndex: make(map[string][]uint32),
+		pageIDs:   make(map[int64]uint32),
 		storage:   storage.NewDiskStorage(indexPath),
 	}
 }
 
 func (idx *Indexer) ProcessFile(filename string) error {
+	return idx.ProcessFiles([]string{filename})
+}
+
+// ProcessFiles parses up to idx.workers files at once, all feeding the same
+// worker pool and sharing one id allocator. at the end what's left in
+// memory is flushed and the ids are put in input order, see renumber
+func (idx *Indexer) ProcessFiles(filenames []string) error {
 	docChan := make(chan *models.Document, 1000)
 
 	// worker goroutines
@@ -44,17 +82,78 @@ func (idx *Indexer) ProcessFile(filename string) error {
 
 			for doc := range docChan {
 				idx.addDocument(doc)
+				idx.build.progress.added()
 			}
 		}()
 	}
 
-	// pain file
-	parser := NewParser(docChan)
-	err := parser.ParseFile(filename)
+	// pain files
+	var (
+		parsers  sync.WaitGroup
+		errMutex sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, max(idx.workers, 1))
+
+	for _, filename := range filenames {
+		parsers.Add(1)
+		sem <- struct{}{}
+
+		go func(filename string) {
+			defer parsers.Done()
+			defer func() { <-sem }()
+
+			if idx.build.full() {
+				return
+			}
+
+			fmt.Printf("Processing file: %s\n", filename)
+			err := idx.parseFile(filename, docChan)
+
+			if err != nil {
+				errMutex.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", filename, err)
+				}
+				errMutex.Unlock()
+			}
+		}(filename)
+	}
+
+	parsers.Wait()
 	close(docChan)
 	wg.Wait()
 
-	return err
+	if firstErr == nil {
+		firstErr = idx.flushErr
+	}
+	// everything after parsing reads the segments, an update writes the
+	// documents it holds as they are
+	if firstErr == nil && idx.base == nil {
+		idx.mutex.Lock()
+		firstErr = idx.flushSegment()
+		idx.mutex.Unlock()
+	}
+	if firstErr == nil {
+		firstErr = idx.renumber()
+	}
+	return firstErr
+}
+
+// parseFile picks the reader for the file's format
+func (idx *Indexer) parseFile(filename string, docChan chan<- *models.Document) error {
+	format := idx.build.formatOf(filename)
+
+	if index := MultistreamIndex(filename); index != "" && format == FormatXML {
+		return NewParser(docChan, idx.build).ParseMultistream(filename, index, idx.workers)
+	}
+
+	reader, err := NewSourceReader(format, docChan, idx.build)
+	if err != nil {
+		return err
+	}
+
+	return reader.ParseFile(filename)
 }
 
 func (idx *Indexer) addDocument(doc *models.Document) {
@@ -64,6 +163,10 @@ func (idx *Indexer) addDocument(doc *models.Document) {
 	idx.documents[doc.ID] = doc
 	idx.docCount++
 
+	if doc.PageID != 0 {
+		idx.pageIDs[doc.PageID] = doc.ID
+	}
+
 	for term := range doc.Terms {
 		if _, exists := idx.termIndex[term]; !exists {
 			idx.termIndex[term] = make([]uint32, 0)
@@ -72,51 +175,167 @@ func (idx *Indexer) addDocument(doc *models.Document) {
 		idx.termIndex[term] = append(idx.termIndex[term], doc.ID)
 	}
 
+	if idx.budget > 0 && idx.flushErr == nil {
+		idx.memory += docMemory(doc)
+		if idx.memory >= idx.budget {
+			idx.flushErr = idx.flushSegment()
+		}
+	}
+
 	if idx.docCount%1000 == 0 {
 		fmt.Printf("Processed %d documents... \n", idx.docCount)
 	}
 }
 
+// BuildIndex runs the stages that need the whole wiki, each a pass over
+// the segments that leaves its results in sorted runs
 func (idx *Indexer) BuildIndex() error {
 	fmt.Println("building index structures")
-	// doc lenth
-	totalLen := 0
-	for _, doc := range idx.documents {
-		totalLen += doc.Length
+
+	if err := idx.resolveAliases(); err != nil {
+		return err
 	}
-	if idx.docCount > 0 {
-		idx.avgDocLen = float64(totalLen) / float64(idx.docCount)
+	if err := idx.resolveLinks(); err != nil {
+		return err
+	}
+	if err := idx.computePageRank(); err != nil {
+		return err
+	}
+	if err := idx.clusterDuplicates(); err != nil {
+		return err
 	}
 
-	fmt.Printf("Total documents: %d\n", idx.docCount)
-	fmt.Printf("Total terms: %d\n", len(idx.termIndex))
-	fmt.Printf("Average document length: %.2f\n", idx.avgDocLen)
-
+	// lengths and terms are only final once the segments are merged
+	fmt.Printf("Total documents: %d in %d segments\n", idx.docCount, idx.segments)
 	return nil
 }
 
+// aliasRequest is a redirect waiting for the title it points at to be
+// looked up
+type aliasRequest struct {
+	Key   string
+	Alias string
+}
+
+func aliasRequestBefore(a, b aliasRequest) bool {
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	return a.Alias < b.Alias
+}
+
+// aliasRecord is a redirect title of the document ID
+type aliasRecord struct {
+	ID    uint32
+	Alias string
+}
+
+func aliasBefore(a, b aliasRecord) bool {
+	if a.ID != b.ID {
+		return a.ID < b.ID
+	}
+	return a.Alias < b.Alias
+}
+
+// resolveAliases attaches every redirect title to the article it points
+// at. mergeSegments indexes them, in title order so the aliases come out
+// the same every build
+func (idx *Indexer) resolveAliases() error {
+	tables, err := idx.lookups()
+	if err != nil {
+		return err
+	}
+
+	requests := &spill[aliasRequest]{
+		dir:    idx.segmentDir(),
+		name:   "redirects",
+		less:   aliasRequestBefore,
+		size:   func(r aliasRequest) int64 { return int64(len(r.Key)+len(r.Alias)) + 32 },
+		budget: idx.budget,
+	}
+	redirects := idx.build.Redirects.Map()
+	for from, to := range redirects {
+		if err := requests.add(aliasRequest{Key: models.TitleKey(resolveRedirect(redirects, to)), Alias: from}); err != nil {
+			return err
+		}
+	}
+	if err := requests.flush(); err != nil {
+		return err
+	}
+
+	aliases := &spill[aliasRecord]{
+		dir:    idx.segmentDir(),
+		name:   "aliases",
+		less:   aliasBefore,
+		size:   func(a aliasRecord) int64 { return int64(len(a.Alias)) + 24 },
+		budget: idx.budget,
+	}
+	resolved := 0
+	err = lookup(tables.titles, requests.paths, aliasRequestBefore, func(r aliasRequest) string { return r.Key }, func(r aliasRequest, id uint32) error {
+		resolved++
+		return aliases.add(aliasRecord{ID: id, Alias: r.Alias})
+	})
+	if err == nil {
+		err = aliases.flush()
+	}
+	if err == nil {
+		err = removeRuns(requests.paths)
+	}
+	idx.aliasRuns = aliases.paths
+
+	fmt.Printf("Resolved %d of %d redirects\n", resolved, len(redirects))
+	return err
+}
+
+// resolveRedirect follows double redirects, giving up on long chains or loops
+func resolveRedirect(redirects map[string]string, title string) string {
+	for i := 0; i < 5; i++ {
+		next, ok := redirects[title]
+		if !ok {
+			break
+		}
+		title = next
+	}
+
+	return title
+}
+
+// SaveToDisk writes the index, merging the segments with whatever the
+// stages found. documents still in memory, like the ones Compact loads,
+// are flushed first
 func (idx *Indexer) SaveToDisk() error {
+	if err := idx.flushSegment(); err != nil {
+		return err
+	}
+	if err := idx.mergeSegments(); err != nil {
+		return err
+	}
+
 	metadata := map[string]interface{}{
         "doc_count":    idx.docCount,
         "avg_doc_len":  idx.avgDocLen,
-        "total_terms":  len(idx.termIndex),
+        "total_terms":  idx.termCount,
+        "language":     idx.build.Language().Code,
+        "url_base":     idx.build.URLBase(),
+        "next_id":      idx.build.IDs.next.Load(),
     }
 
-	metaFile := filepath.Join(idx.indexPath, "metadata.json")
-	metaData, _ := json.Marshal(metadata)
-	if err := os.WriteFile(metaFile, metaData, 0644); err != nil{
+	if err := writeMetadata(idx.indexPath, metadata); err != nil{
+		return err
+	}
+
+	// updates saved for the previous build don't apply to this one
+	if err := idx.storage.RemoveDeltas(); err != nil {
 		return err
 	}
 
-	// savin documents
-	fmt.Println("saving docs...")
-	if err := idx.storage.SaveDocuments(idx.documents); err != nil {
+	// the index is complete, nothing left to resume
+	if err := idx.storage.RemoveCheckpoint(); err != nil {
 		return err
 	}
 
-	// savin term index
-	fmt.Println("saving term index")
-	if err := idx.storage.SaveTermIndex(idx.termIndex); err != nil{
+	// the segments and every run
+	if err := os.RemoveAll(idx.segmentDir()); err != nil {
 		return err
 	}
 
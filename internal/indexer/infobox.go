@@ -0,0 +1,156 @@
+package indexer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/wikitext"
+)
+
+var (
+	months = `(January|February|March|April|May|June|July|August|September|October|November|December)`
+
+	isoDateRe = regexp.MustCompile(`^(\d{4})-(\d{2})-(\d{2})\b`)
+	dmyDateRe = regexp.MustCompile(`^(\d{1,2}) ` + months + ` (\d{3,4})\b`)
+	mdyDateRe = regexp.MustCompile(`^` + months + ` (\d{1,2}), (\d{3,4})\b`)
+	yearRe    = regexp.MustCompile(`^(\d{3,4})\b`)
+	numberRe  = regexp.MustCompile(`^[^\d\-+]{0,3}([-+]?\d[\d,]*(?:\.\d+)?)\s*(thousand|million|billion|trillion)?`)
+
+	monthNumbers = map[string]int{
+		"january": 1, "february": 2, "march": 3, "april": 4, "may": 5, "june": 6,
+		"july": 7, "august": 8, "september": 9, "october": 10, "november": 11, "december": 12,
+	}
+	multipliers = map[string]float64{
+		"thousand": 1e3, "million": 1e6, "billion": 1e9, "trillion": 1e12,
+	}
+)
+
+// infoboxOf pulls the first {{Infobox ...}} template into key/value pairs,
+// with numbers and dates normalized for range filters
+func infoboxOf(parsed *wikitext.Page) *models.Infobox {
+	for _, t := range parsed.Templates {
+		if !strings.HasPrefix(strings.ToLower(t.Name), "infobox") {
+			continue
+		}
+
+		box := &models.Infobox{
+			Name:    strings.TrimSpace(t.Name[len("infobox"):]),
+			Fields:  make(map[string]string),
+			Numbers: make(map[string]float64),
+			Dates:   make(map[string]string),
+		}
+
+		for _, arg := range t.Args {
+			key := models.FieldKey(arg.Name)
+			if key == "" {
+				continue
+			}
+
+			if date, ok := normalizeDate(key, arg); ok {
+				box.Dates[key] = date
+				if arg.Value == "" {
+					arg.Value = date
+				}
+			} else if n, ok := normalizeNumber(arg.Value); ok {
+				box.Numbers[key] = n
+			}
+
+			if arg.Value != "" {
+				box.Fields[key] = arg.Value
+			}
+		}
+
+		return box
+	}
+
+	return nil
+}
+
+// normalizeDate understands {{birth date|1879|3|14}} style templates and
+// the usual written forms, and returns yyyy-mm-dd. a written date has to
+// start the value, "2,140,526 (1 January 2019)" is a number
+func normalizeDate(key string, arg wikitext.Arg) (string, bool) {
+	for _, t := range wikitext.Parse(arg.Raw).Templates {
+		if !strings.Contains(strings.ToLower(t.Name), "date") {
+			continue
+		}
+
+		var parts []int
+		for i := 0; len(parts) < 3; i++ {
+			value := t.Positional(i)
+			if value == "" {
+				break
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				break
+			}
+			parts = append(parts, n)
+		}
+		if len(parts) > 0 {
+			return formatDate(parts...), true
+		}
+	}
+
+	value := arg.Value
+	if m := isoDateRe.FindStringSubmatch(value); m != nil {
+		return m[0], true
+	}
+	if m := dmyDateRe.FindStringSubmatch(value); m != nil {
+		return formatDate(atoi(m[3]), monthNumbers[strings.ToLower(m[2])], atoi(m[1])), true
+	}
+	if m := mdyDateRe.FindStringSubmatch(value); m != nil {
+		return formatDate(atoi(m[3]), monthNumbers[strings.ToLower(m[1])], atoi(m[2])), true
+	}
+
+	// a bare year only counts when the field is obviously about time
+	if strings.Contains(key, "date") || strings.Contains(key, "year") || strings.Contains(key, "founded") || strings.Contains(key, "established") {
+		if m := yearRe.FindStringSubmatch(value); m != nil {
+			return formatDate(atoi(m[1])), true
+		}
+	}
+
+	return "", false
+}
+
+// normalizeNumber reads a leading number like "2,140,526 (2019)" or
+// "€1.5 billion"
+func normalizeNumber(value string) (float64, bool) {
+	m := numberRe.FindStringSubmatch(value)
+	if m == nil {
+		return 0, false
+	}
+
+	n, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64)
+	if err != nil {
+		return 0, false
+	}
+	if mult, ok := multipliers[strings.ToLower(m[2])]; ok {
+		n *= mult
+	}
+
+	return n, true
+}
+
+// formatDate fills missing month and day with 1
+func formatDate(parts ...int) string {
+	for len(parts) < 3 {
+		parts = append(parts, 1)
+	}
+	if parts[1] < 1 || parts[1] > 12 {
+		parts[1] = 1
+	}
+	if parts[2] < 1 || parts[2] > 31 {
+		parts[2] = 1
+	}
+
+	return fmt.Sprintf("%04d-%02d-%02d", parts[0], parts[1], parts[2])
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,61 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/wikitext"
+)
+
+func TestInfobox(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want *models.Infobox
+	}{
+		{
+			name: "none",
+			src:  "{{Short description|A city}} Just text.",
+		},
+		{
+			name: "numbers with a date after them",
+			src:  "{{Infobox settlement\n| name = Paris\n| population = 2,140,526 (1 January 2019)\n| area_km2 = 105.4\n| gdp = €739 billion\n}}",
+			want: &models.Infobox{
+				Name:    "settlement",
+				Fields:  map[string]string{"name": "Paris", "population": "2,140,526 (1 January 2019)", "area_km2": "105.4", "gdp": "€739 billion"},
+				Numbers: map[string]float64{"population": 2140526, "area_km2": 105.4, "gdp": 739e9},
+				Dates:   map[string]string{},
+			},
+		},
+		{
+			name: "dates",
+			src:  "{{Infobox person\n| birth_date = {{birth date|1879|3|14}}\n| death_date = 18 April 1955 (aged 76)\n| signed = April 2, 1921\n| married = 1903-01-06\n| founded = 1905\n| height = 1905\n}}",
+			want: &models.Infobox{
+				Name:    "person",
+				Fields:  map[string]string{"birth_date": "1879-03-14", "death_date": "18 April 1955 (aged 76)", "signed": "April 2, 1921", "married": "1903-01-06", "founded": "1905", "height": "1905"},
+				Numbers: map[string]float64{"height": 1905},
+				Dates:   map[string]string{"birth_date": "1879-03-14", "death_date": "1955-04-18", "signed": "1921-04-02", "married": "1903-01-06", "founded": "1905-01-01"},
+			},
+		},
+		{
+			name: "written dates later in the value",
+			src:  "{{Infobox company\n| employees = 12 (March 2020)\n| key_people = Ada, since 2 May 1999\n}}",
+			want: &models.Infobox{
+				Name:    "company",
+				Fields:  map[string]string{"employees": "12 (March 2020)", "key_people": "Ada, since 2 May 1999"},
+				Numbers: map[string]float64{"employees": 12},
+				Dates:   map[string]string{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := infoboxOf(wikitext.Parse(tt.src))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("infobox = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package indexer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// JSONLRecord is one line of a jsonl corpus. only title and text (or
+// content) are required. in an update, a record with deleted set removes
+// the page with its id
+type JSONLRecord struct {
+	ID         int64     `json:"id"`
+	Title      string    `json:"title"`
+	Text       string    `json:"text"`
+	Content    string    `json:"content"`
+	URL        string    `json:"url"`
+	Categories []string  `json:"categories"`
+	Modified   time.Time `json:"modified"`
+	Author     string    `json:"author"`
+	Deleted    bool      `json:"deleted"`
+}
+
+type JSONLReader struct {
+	docChan chan<- *models.Document
+	build   *Build
+}
+
+func (r *JSONLReader) ParseFile(filename string) error {
+	if r.build.progress.offset(filename) == streamDone {
+		return nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return r.build.parseLines(file, filename, func(line []byte, offset int64) bool {
+		return r.handleLine(line, filename, offset)
+	})
+}
+
+// parseLines calls handle for every line of a line delimited stream with
+// the offset it starts at, skipping the ones a resumed build already has.
+// key names the stream for checkpoints. handle is false when -max-docs
+// refused the line, the stream stops before it
+func (b *Build) parseLines(reader io.Reader, key string, handle func(line []byte, offset int64) bool) error {
+	progress := b.progress
+
+	resume := progress.offset(key)
+	if resume == streamDone {
+		return nil
+	}
+
+	buffered := bufio.NewReader(reader)
+	offset := int64(0)
+
+	for {
+		if b.full() {
+			return nil
+		}
+
+		line, err := buffered.ReadBytes('\n')
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			progress.begin()
+			progress.end(key, streamDone)
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		start := offset
+		offset += int64(len(line))
+
+		// done before the checkpoint
+		if offset <= resume {
+			continue
+		}
+
+		progress.begin()
+		if !handle(line, start) {
+			progress.end("", 0)
+			return nil
+		}
+		progress.end(key, offset)
+	}
+}
+
+// handleLine is false when -max-docs refused the record
+func (r *JSONLReader) handleLine(line []byte, filename string, offset int64) bool {
+	if len(strings.TrimSpace(string(line))) == 0 {
+		return true
+	}
+
+	var record JSONLRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		r.build.skip(Skip{Reason: SkipMalformed, File: filename, Offset: offset, Error: err.Error()})
+		return true
+	}
+
+	if record.Deleted {
+		if record.ID != 0 {
+			r.build.Deletions.Add(record.ID)
+		}
+		return true
+	}
+
+	text := record.Text
+	if text == "" {
+		text = record.Content
+	}
+	title := strings.TrimSpace(record.Title)
+	if title == "" || len(strings.TrimSpace(text)) < 50 {
+		r.build.skip(Skip{Reason: SkipTooShort, File: filename, Offset: offset, Title: title, PageID: record.ID})
+		return true
+	}
+	if ok, full := r.build.admit(Skip{File: filename, Offset: offset, Title: title, PageID: record.ID}); !ok {
+		return !full
+	}
+
+	url := record.URL
+	if url == "" {
+		url = fileURL(filename) + "#" + strings.ReplaceAll(title, " ", "_")
+	}
+
+	var tb textBuilder
+	for _, paragraph := range strings.Split(text, "\n") {
+		tb.paragraph(paragraph)
+	}
+	content, sections := tb.finish()
+
+	doc := models.NewDocument(r.build.IDs.Next(), title, content, url, r.build.Language())
+	doc.PageID = record.ID
+	doc.Sections = sections
+	doc.Categories = record.Categories
+	doc.Modified = record.Modified
+	doc.Contributor = record.Author
+
+	r.build.send(r.docChan, doc, filename, offset)
+
+	return true
+}
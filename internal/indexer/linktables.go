@@ -0,0 +1,361 @@
+package indexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/wikitext"
+)
+
+// linkRecord is a row of the pagelinks table, between two indexed documents
+type linkRecord struct {
+	From, To uint32
+}
+
+func linkBefore(a, b linkRecord) bool {
+	if a.From != b.From {
+		return a.From < b.From
+	}
+	return a.To < b.To
+}
+
+// linkTarget is a row of the linktarget table, which newer pagelinks and
+// categorylinks dumps point into instead of carrying titles
+type linkTarget struct {
+	namespace int
+	title     string
+}
+
+// ImportSQL joins wikimedia's sql table dumps (see SQLTable) to the indexed
+// documents by page id. run it after the pages are parsed and before
+// BuildIndex. pagelinks replaces the links taken from the text of every page
+// it has rows for, categorylinks and redirect add to what the text had
+func (idx *Indexer) ImportSQL(files []string) error {
+	tables := make(map[string]string)
+	for _, file := range files {
+		if table := SQLTable(file); table != "" {
+			tables[table] = file
+		}
+	}
+
+	var targets map[int64]linkTarget
+	if file := tables["linktarget"]; file != "" {
+		fmt.Printf("Importing %s\n", file)
+		var err error
+		if targets, err = readLinkTargets(file); err != nil {
+			return err
+		}
+	}
+
+	// redirects go first so page links can be followed through them
+	if file := tables["redirect"]; file != "" {
+		if tables["page"] == "" {
+			fmt.Printf("Skipping %s, the redirect titles come from the page table dump\n", file)
+		} else if err := idx.importRedirects(file, tables["page"]); err != nil {
+			return err
+		}
+	}
+
+	if file := tables["categorylinks"]; file != "" {
+		if err := idx.importCategories(file, targets); err != nil {
+			return err
+		}
+	}
+
+	if file := tables["pagelinks"]; file != "" {
+		if err := idx.importPageLinks(file, targets); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readLinkTargets(file string) (map[int64]linkTarget, error) {
+	targets := make(map[int64]linkTarget)
+
+	err := readSQLDump(file, func(row sqlRow) {
+		id, _ := rowInt(row, "lt_id")
+		ns, _ := rowInt(row, "lt_namespace")
+		title, _ := row.get("lt_title")
+
+		// only articles and categories are ever looked up
+		if ns == wikitext.NSMain || ns == wikitext.NSCategory {
+			targets[id] = linkTarget{namespace: int(ns), title: sqlTitle(title)}
+		}
+	})
+
+	return targets, err
+}
+
+// importRedirects needs two passes, redirect only has the page id of the
+// redirect page and its title is in page
+func (idx *Indexer) importRedirects(redirectFile, pageFile string) error {
+	fmt.Printf("Importing %s\n", redirectFile)
+
+	redirects := make(map[int64]string)
+	err := readSQLDump(redirectFile, func(row sqlRow) {
+		from, _ := rowInt(row, "rd_from")
+		ns, _ := rowInt(row, "rd_namespace")
+		title, _ := row.get("rd_title")
+
+		if interwiki, _ := row.get("rd_interwiki"); ns == wikitext.NSMain && (interwiki == "" || interwiki == "NULL") {
+			redirects[from] = sqlTitle(title)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Importing %s\n", pageFile)
+	added := 0
+	err = readSQLDump(pageFile, func(row sqlRow) {
+		id, _ := rowInt(row, "page_id")
+		target, ok := redirects[id]
+		if !ok {
+			return
+		}
+
+		ns, _ := rowInt(row, "page_namespace")
+		title, _ := row.get("page_title")
+		if idx.build.Options.indexesNamespace(int(ns)) {
+			idx.build.Redirects.Add(sqlTitle(title), target)
+			added++
+		}
+	})
+
+	fmt.Printf("Imported %d redirects\n", added)
+	return err
+}
+
+// categoryRequest is a categorylinks row waiting for its page to be
+// looked up, Row keeps the order of the dump
+type categoryRequest struct {
+	PageID   int64
+	Row      int64
+	Category string
+}
+
+func categoryRequestBefore(a, b categoryRequest) bool {
+	if a.PageID != b.PageID {
+		return a.PageID < b.PageID
+	}
+	return a.Row < b.Row
+}
+
+// categoryRecord is a category of the document ID from the dump
+type categoryRecord struct {
+	ID       uint32
+	Row      int64
+	Category string
+}
+
+func categoryBefore(a, b categoryRecord) bool {
+	if a.ID != b.ID {
+		return a.ID < b.ID
+	}
+	return a.Row < b.Row
+}
+
+// importCategories spills the rows by page id and looks their documents
+// up, mergeSegments adds the categories the text didn't have
+func (idx *Indexer) importCategories(file string, targets map[int64]linkTarget) error {
+	fmt.Printf("Importing %s\n", file)
+
+	tables, err := idx.lookups()
+	if err != nil {
+		return err
+	}
+
+	requests := &spill[categoryRequest]{
+		dir:    idx.segmentDir(),
+		name:   "category-rows",
+		less:   categoryRequestBefore,
+		size:   func(r categoryRequest) int64 { return int64(len(r.Category)) + 40 },
+		budget: idx.budget,
+	}
+	rows := int64(0)
+	var spillErr error
+	err = readSQLDump(file, func(row sqlRow) {
+		if spillErr != nil {
+			return
+		}
+
+		// subcategories and files are listed too
+		if kind, ok := row.get("cl_type"); ok && kind != "page" {
+			return
+		}
+
+		category, ok := row.get("cl_to")
+		if ok {
+			category = sqlTitle(category)
+		} else if id, ok := rowInt(row, "cl_target_id"); ok {
+			category = targets[id].title
+		}
+		if category == "" {
+			return
+		}
+
+		from, _ := rowInt(row, "cl_from")
+		rows++
+		spillErr = requests.add(categoryRequest{PageID: from, Row: rows, Category: category})
+	})
+	if err == nil {
+		err = spillErr
+	}
+	if err == nil {
+		err = requests.flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	categories := &spill[categoryRecord]{
+		dir:    idx.segmentDir(),
+		name:   "categories",
+		less:   categoryBefore,
+		size:   func(c categoryRecord) int64 { return int64(len(c.Category)) + 40 },
+		budget: idx.budget,
+	}
+	added := 0
+	err = lookup(tables.pages, requests.paths, categoryRequestBefore, func(r categoryRequest) int64 { return r.PageID }, func(r categoryRequest, id uint32) error {
+		added++
+		return categories.add(categoryRecord{ID: id, Row: r.Row, Category: r.Category})
+	})
+	if err == nil {
+		err = categories.flush()
+	}
+	if err == nil {
+		err = removeRuns(requests.paths)
+	}
+	idx.categoryRuns = categories.paths
+
+	fmt.Printf("Imported %d category links of indexed pages\n", added)
+	return err
+}
+
+// pageLinkRequest is a pagelinks row waiting for the document of its page
+// to be looked up
+type pageLinkRequest struct {
+	PageID int64
+	Key    string
+}
+
+func pageLinkRequestBefore(a, b pageLinkRequest) bool {
+	if a.PageID != b.PageID {
+		return a.PageID < b.PageID
+	}
+	return a.Key < b.Key
+}
+
+// importPageLinks spills the links to sorted runs under the memory budget,
+// the table has over a billion rows for enwiki. they're looked up by page
+// id and then by target title, writeLinkGraph reads the result
+func (idx *Indexer) importPageLinks(file string, targets map[int64]linkTarget) error {
+	fmt.Printf("Importing %s\n", file)
+
+	tables, err := idx.lookups()
+	if err != nil {
+		return err
+	}
+
+	rows := &spill[pageLinkRequest]{
+		dir:    idx.segmentDir(),
+		name:   "link-rows",
+		less:   pageLinkRequestBefore,
+		size:   func(r pageLinkRequest) int64 { return int64(len(r.Key)) + 32 },
+		budget: idx.budget,
+	}
+	redirects := idx.build.Redirects.Map()
+	var spillErr error
+	err = readSQLDump(file, func(row sqlRow) {
+		if spillErr != nil {
+			return
+		}
+
+		var target linkTarget
+		if row.has("pl_title") {
+			ns, _ := rowInt(row, "pl_namespace")
+			title, _ := row.get("pl_title")
+			target = linkTarget{namespace: int(ns), title: sqlTitle(title)}
+		} else if id, ok := rowInt(row, "pl_target_id"); ok {
+			target = targets[id]
+		}
+
+		if target.namespace != wikitext.NSMain || target.title == "" {
+			return
+		}
+
+		from, _ := rowInt(row, "pl_from")
+		spillErr = rows.add(pageLinkRequest{PageID: from, Key: models.TitleKey(resolveRedirect(redirects, target.title))})
+	})
+	if err == nil {
+		err = spillErr
+	}
+	if err == nil {
+		err = rows.flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	requests := &spill[linkRequest]{
+		dir:    idx.segmentDir(),
+		name:   "link-targets",
+		less:   linkRequestBefore,
+		size:   func(r linkRequest) int64 { return int64(len(r.Key)) + 40 },
+		budget: idx.budget,
+	}
+	err = lookup(tables.pages, rows.paths, pageLinkRequestBefore, func(r pageLinkRequest) int64 { return r.PageID }, func(r pageLinkRequest, from uint32) error {
+		return requests.add(linkRequest{Key: r.Key, From: from})
+	})
+	if err == nil {
+		err = requests.flush()
+	}
+	if err == nil {
+		err = removeRuns(rows.paths)
+	}
+	if err != nil {
+		return err
+	}
+
+	links := &spill[linkRecord]{
+		dir:    idx.segmentDir(),
+		name:   "links",
+		less:   linkBefore,
+		size:   func(linkRecord) int64 { return 8 },
+		budget: idx.budget,
+	}
+	imported := 0
+	err = lookup(tables.titles, requests.paths, linkRequestBefore, func(r linkRequest) string { return r.Key }, func(r linkRequest, to uint32) error {
+		imported++
+		return links.add(linkRecord{From: r.From, To: to})
+	})
+	if err == nil {
+		err = links.flush()
+	}
+	if err == nil {
+		err = removeRuns(requests.paths)
+	}
+	idx.linkRuns = links.paths
+
+	fmt.Printf("Imported %d links\n", imported)
+	return err
+}
+
+func rowInt(row sqlRow, column string) (int64, bool) {
+	value, ok := row.get(column)
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	return n, err == nil
+}
+
+// sqlTitle turns the underscored titles of the tables into page titles
+func sqlTitle(title string) string {
+	return strings.ReplaceAll(title, "_", " ")
+}
@@ -0,0 +1,144 @@
+package indexer
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// the stages after parsing never hold a map of every document. what they
+// look up by title or page id (link targets, redirects, sql rows,
+// pageviews) is spilled sorted by that key and walked alongside a table
+// sorted the same way, like a merge join
+
+// keyRecord ties a title key or a page id to a document
+type keyRecord[K cmp.Ordered] struct {
+	Key K
+	ID  uint32
+}
+
+func keyBefore[K cmp.Ordered](a, b keyRecord[K]) bool {
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	return a.ID < b.ID
+}
+
+// lookupTables are the runs of every document's title key and page id
+type lookupTables struct {
+	titles []string
+	pages  []string
+	docs   int    // documents in the tables
+	maxID  uint32 // highest id among them
+}
+
+// lookups writes the tables once, after the documents still in memory are
+// flushed. a new segment or new ids make them stale
+func (idx *Indexer) lookups() (*lookupTables, error) {
+	if idx.tables != nil {
+		return idx.tables, nil
+	}
+
+	if err := idx.flushSegment(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(idx.segmentDir(), 0755); err != nil {
+		return nil, err
+	}
+
+	titles := &spill[keyRecord[string]]{
+		dir:    idx.segmentDir(),
+		name:   "titles",
+		less:   keyBefore[string],
+		size:   func(r keyRecord[string]) int64 { return int64(len(r.Key)) + 24 },
+		budget: idx.budget,
+	}
+	pages := &spill[keyRecord[int64]]{
+		dir:    idx.segmentDir(),
+		name:   "pages",
+		less:   keyBefore[int64],
+		size:   func(keyRecord[int64]) int64 { return 16 },
+		budget: idx.budget,
+	}
+
+	tables := &lookupTables{}
+	err := idx.eachDocument(func(doc *models.Document) error {
+		tables.docs++
+		tables.maxID = max(tables.maxID, doc.ID)
+
+		if err := titles.add(keyRecord[string]{Key: models.TitleKey(doc.Title), ID: doc.ID}); err != nil {
+			return err
+		}
+		if doc.PageID == 0 {
+			return nil
+		}
+		return pages.add(keyRecord[int64]{Key: doc.PageID, ID: doc.ID})
+	})
+	if err == nil {
+		err = titles.flush()
+	}
+	if err == nil {
+		err = pages.flush()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("writing lookup tables: %w", err)
+	}
+
+	tables.titles, tables.pages = titles.paths, pages.paths
+	idx.tables = tables
+	return tables, nil
+}
+
+// lookup calls fn with every request and the document its key belongs to,
+// skipping the keys no document has. the requests have to be sorted by key
+// and so is the table. a title or page that's in the input twice finds the
+// copy read last, the one with the higher id
+func lookup[K cmp.Ordered, T any](table, requests []string, less func(a, b T) bool, key func(v T) K, fn func(v T, id uint32) error) error {
+	rows, err := mergeRuns(table, keyBefore[K])
+	if err != nil {
+		return err
+	}
+	defer rows.close()
+
+	reqs, err := mergeRuns(requests, less)
+	if err != nil {
+		return err
+	}
+	defer reqs.close()
+
+	var current K
+	id, started := uint32(0), false
+	for {
+		req, ok, err := reqs.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+
+		if k := key(req); !started || k != current {
+			current, id, started = k, 0, true
+			for {
+				row, ok := rows.peek()
+				if !ok || row.Key > k {
+					break
+				}
+				if _, _, err := rows.next(); err != nil {
+					return err
+				}
+				if row.Key == k {
+					id = row.ID
+				}
+			}
+		}
+
+		if id != 0 {
+			if err := fn(req, id); err != nil {
+				return err
+			}
+		}
+	}
+}
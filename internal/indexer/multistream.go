@@ -0,0 +1,178 @@
+package indexer
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MultistreamIndex returns the offsets index that wikimedia publishes next to
+// a pages-articles-multistream dump, or "" when there isn't one
+//
+//	enwiki-latest-pages-articles-multistream.xml.bz2
+//	enwiki-latest-pages-articles-multistream-index.txt.bz2
+func MultistreamIndex(filename string) string {
+	base := filepath.Base(filename)
+	if !strings.Contains(base, "multistream") || IsMultistreamIndex(base) || !strings.HasSuffix(base, ".bz2") {
+		return ""
+	}
+
+	name := strings.Replace(base, "multistream", "multistream-index", 1)
+	name = strings.Replace(name, ".xml", ".txt", 1)
+
+	index := filepath.Join(filepath.Dir(filename), name)
+	if _, err := os.Stat(index); err != nil {
+		return ""
+	}
+
+	return index
+}
+
+func IsMultistreamIndex(filename string) bool {
+	return strings.Contains(filepath.Base(filename), "multistream-index")
+}
+
+// ParseMultistream decompresses and parses the independent bz2 streams of a
+// multistream dump on up to workers goroutines
+func (p *Parser) ParseMultistream(filename, indexFile string, workers int) error {
+	// with a selection only the streams holding a selected title are read
+	var keep func(title string) bool
+	if p.build.selector.filters() {
+		keep = p.build.selector.selects
+	}
+
+	offsets, wanted, err := readStreamOffsets(indexFile, keep)
+	if err != nil {
+		return fmt.Errorf("reading stream index: %w", err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// the first stream only holds <siteinfo>, which the rest depend on. it's
+	// left untracked so a resumed build reads it again
+	if len(offsets) == 0 || offsets[0] > 0 {
+		end := info.Size()
+		if len(offsets) > 0 {
+			end = offsets[0]
+		}
+		if err := p.parseStream(file, "", 0, end); err != nil {
+			return err
+		}
+	}
+
+	type stream struct{ start, end int64 }
+	streams := make(chan stream)
+
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for s := range streams {
+				key := fmt.Sprintf("%s@%d", filename, s.start)
+				if err := p.parseStream(file, key, s.start, s.end); err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("stream at offset %d: %w", s.start, err)
+					}
+					errMutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i, start := range offsets {
+		if p.build.full() {
+			break
+		}
+		if wanted != nil && !wanted[start] {
+			continue
+		}
+
+		end := info.Size()
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+		streams <- stream{start, end}
+	}
+	close(streams)
+	wg.Wait()
+
+	return firstErr
+}
+
+func (p *Parser) parseStream(file io.ReaderAt, key string, start, end int64) error {
+	reader := bzip2.NewReader(io.NewSectionReader(file, start, end-start))
+	return p.parse(reader, key, true)
+}
+
+// readStreamOffsets reads "offset:pageid:title" lines and returns the
+// distinct stream offsets in ascending order. given keep, it also returns
+// the offsets of the streams holding a title keep is true for
+func readStreamOffsets(indexFile string, keep func(title string) bool) ([]int64, map[int64]bool, error) {
+	file, err := os.Open(indexFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(indexFile, ".bz2") {
+		reader = bzip2.NewReader(file)
+	}
+
+	var offsets []int64
+	var wanted map[int64]bool
+	if keep != nil {
+		wanted = make(map[int64]bool)
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		field, rest, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+
+		offset, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad index line %q: %w", scanner.Text(), err)
+		}
+		if len(offsets) == 0 || offsets[len(offsets)-1] != offset {
+			offsets = append(offsets, offset)
+		}
+
+		if keep != nil && !wanted[offset] {
+			if _, title, _ := strings.Cut(rest, ":"); keep(title) {
+				wanted[offset] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	slices.Sort(offsets)
+	return slices.Compact(offsets), wanted, nil
+}
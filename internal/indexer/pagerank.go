@@ -0,0 +1,273 @@
+package indexer
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+const (
+	damping       = 0.85
+	maxIterations = 50
+	tolerance     = 1e-6 // largest change of any page's scaled score
+)
+
+// linkRequest is a link from the document From waiting for its target
+// title to be looked up, Label is empty when it carries no anchor text
+type linkRequest struct {
+	Key   string
+	From  uint32
+	Label string
+}
+
+func linkRequestBefore(a, b linkRequest) bool {
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	if a.From != b.From {
+		return a.From < b.From
+	}
+	return a.Label < b.Label
+}
+
+// resolveLinks looks up the links of every text, following redirects, and
+// spills them twice: as edges for pagerank and as anchor text for the
+// target, counted per distinct label by mergeSegments. self links are
+// neither
+func (idx *Indexer) resolveLinks() error {
+	tables, err := idx.lookups()
+	if err != nil {
+		return err
+	}
+
+	requests := &spill[linkRequest]{
+		dir:    idx.segmentDir(),
+		name:   "link-requests",
+		less:   linkRequestBefore,
+		size:   func(r linkRequest) int64 { return int64(len(r.Key)+len(r.Label)) + 40 },
+		budget: idx.budget,
+	}
+	redirects := idx.build.Redirects.Map()
+	total, resolved := 0, 0
+	err = idx.eachDocument(func(doc *models.Document) error {
+		total += len(doc.Links)
+		for _, link := range doc.Links {
+			err := requests.add(linkRequest{
+				Key:   models.TitleKey(resolveRedirect(redirects, link.Target)),
+				From:  doc.ID,
+				Label: strings.Join(strings.Fields(link.Label), " "),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = requests.flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	links := &spill[linkRecord]{
+		dir:    idx.segmentDir(),
+		name:   "text-links",
+		less:   linkBefore,
+		size:   func(linkRecord) int64 { return 8 },
+		budget: idx.budget,
+	}
+	anchors := &spill[anchorRecord]{
+		dir:    idx.segmentDir(),
+		name:   "anchors",
+		less:   anchorBefore,
+		size:   func(a anchorRecord) int64 { return int64(len(a.Text)) + 40 },
+		budget: idx.budget,
+	}
+	err = lookup(tables.titles, requests.paths, linkRequestBefore, func(r linkRequest) string { return r.Key }, func(r linkRequest, to uint32) error {
+		if to == r.From {
+			return nil
+		}
+		resolved++
+		if err := links.add(linkRecord{From: r.From, To: to}); err != nil {
+			return err
+		}
+		if r.Label == "" {
+			return nil
+		}
+		return anchors.add(anchorRecord{Target: to, Text: r.Label})
+	})
+	if err == nil {
+		err = links.flush()
+	}
+	if err == nil {
+		err = anchors.flush()
+	}
+	if err == nil {
+		err = removeRuns(requests.paths)
+	}
+	idx.textLinkRuns, idx.anchorRuns = links.paths, anchors.paths
+
+	fmt.Printf("Resolved %d of %d links\n", resolved, total)
+	return err
+}
+
+// writeLinkGraph writes the edges pagerank iterates over to one run in
+// from order: the pagelinks rows of every page that has any, the links of
+// its text otherwise, without duplicates and self links. it returns the
+// out degree of every doc id and the number of edges
+func (idx *Indexer) writeLinkGraph(path string, maxID uint32) ([]uint32, int, error) {
+	table, err := mergeRuns(idx.linkRuns, linkBefore)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer table.close()
+
+	text, err := mergeRuns(idx.textLinkRuns, linkBefore)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer text.close()
+
+	out, err := createRun[linkRecord](path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	degree := make([]uint32, maxID+1)
+	edges := 0
+	var last linkRecord
+	write := func(link linkRecord) error {
+		if link == last || link.From == link.To || link.From > maxID || link.To > maxID {
+			return nil
+		}
+		last = link
+		degree[link.From]++
+		edges++
+		return out.write(link)
+	}
+	skip := func(linkRecord) error { return nil }
+	from := func(link linkRecord) uint32 { return link.From }
+
+	for err == nil {
+		a, tableOK := table.peek()
+		b, textOK := text.peek()
+		if !tableOK && !textOK {
+			break
+		}
+
+		// the pagelinks table beats what the parser found in the text
+		if tableOK && (!textOK || a.From <= b.From) {
+			if err = takeFor(table, a.From, from, write); err == nil {
+				err = takeFor(text, a.From, from, skip)
+			}
+		} else {
+			err = takeFor(text, b.From, from, write)
+		}
+	}
+	if err != nil {
+		out.file.Close()
+		return nil, 0, err
+	}
+
+	return degree, edges, out.close()
+}
+
+// rankRecord is the pagerank of the document ID, scaled so that the
+// average article scores 1
+type rankRecord struct {
+	ID   uint32
+	Rank float64
+}
+
+func rankBefore(a, b rankRecord) bool {
+	return a.ID < b.ID
+}
+
+// computePageRank runs power iteration over the link graph, reading its
+// edges from disk every iteration. only the scores and out degrees are
+// held, indexed by doc id, which renumber made 1 to the number of
+// documents
+func (idx *Indexer) computePageRank() error {
+	tables, err := idx.lookups()
+	if err != nil {
+		return err
+	}
+	n := tables.docs
+	if n == 0 {
+		return nil
+	}
+	if int(tables.maxID) != n {
+		return fmt.Errorf("pagerank needs doc ids 1 to %d, the highest is %d", n, tables.maxID)
+	}
+
+	graph := filepath.Join(idx.segmentDir(), "graph.gob")
+	degree, edges, err := idx.writeLinkGraph(graph, tables.maxID)
+	if err != nil {
+		return err
+	}
+
+	rank := make([]float64, n+1)
+	for i := 1; i <= n; i++ {
+		rank[i] = 1 / float64(n)
+	}
+
+	next := make([]float64, n+1)
+	converged := false
+	for iterations := 0; iterations < maxIterations && !converged; iterations++ {
+		// pages without links share their rank with everyone
+		dangling := 0.0
+		for i := 1; i <= n; i++ {
+			if degree[i] == 0 {
+				dangling += rank[i]
+			}
+		}
+
+		base := (1-damping)/float64(n) + damping*dangling/float64(n)
+		for i := 1; i <= n; i++ {
+			next[i] = base
+		}
+		err := eachValue(graph, func(link linkRecord) error {
+			next[link.To] += damping * rank[link.From] / float64(degree[link.From])
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// the ranks sum to 1, so compare them scaled the way they're stored.
+		// a sum over the whole graph would never get under the tolerance
+		delta := 0.0
+		for i := 1; i <= n; i++ {
+			delta = max(delta, math.Abs(next[i]-rank[i]))
+		}
+		rank, next = next, rank
+
+		converged = delta*float64(n) < tolerance
+	}
+
+	ranks := filepath.Join(idx.segmentDir(), "ranks.gob")
+	out, err := createRun[rankRecord](ranks)
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= n; i++ {
+		if err := out.write(rankRecord{ID: uint32(i), Rank: rank[i] * float64(n)}); err != nil {
+			out.file.Close()
+			return err
+		}
+	}
+	if err := out.close(); err != nil {
+		return err
+	}
+	idx.rankRuns = []string{ranks}
+
+	fmt.Printf("PageRank over %d links\n", edges)
+	if !converged {
+		fmt.Printf("PageRank hit the cap of %d iterations before converging\n", maxIterations)
+	}
+	return removeRuns([]string{graph})
+}
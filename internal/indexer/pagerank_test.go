@@ -0,0 +1,99 @@
+package indexer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// referenceRanks is plain power iteration run far past any tolerance
+func referenceRanks(out map[string][]string, titles []string) map[string]float64 {
+	n := float64(len(titles))
+	rank := make(map[string]float64)
+	for _, title := range titles {
+		rank[title] = 1 / n
+	}
+
+	for i := 0; i < 1000; i++ {
+		dangling := 0.0
+		for _, title := range titles {
+			if len(out[title]) == 0 {
+				dangling += rank[title]
+			}
+		}
+
+		next := make(map[string]float64)
+		for _, title := range titles {
+			next[title] = (1-damping)/n + damping*dangling/n
+		}
+		for _, title := range titles {
+			for _, to := range out[title] {
+				next[to] += damping * rank[title] / float64(len(out[title]))
+			}
+		}
+		rank = next
+	}
+
+	for title := range rank {
+		rank[title] *= n
+	}
+
+	return rank
+}
+
+func TestPageRank(t *testing.T) {
+	titles := []string{"Paris", "France", "Lyon", "Nice", "Brest", "Seine", "Loire"}
+	out := map[string][]string{
+		"France": {"Paris", "Lyon", "Nice", "Brest"},
+		"Lyon":   {"Paris", "France"},
+		"Nice":   {"Paris", "France"},
+		"Brest":  {"Paris"},
+		"Seine":  {"Paris", "France"},
+		"Paris":  {"France", "Seine"},
+		// Loire links nowhere and nothing links to it
+	}
+
+	idx := NewIndexer(t.TempDir(), 1, Options{})
+	var docs []*models.Document
+	for i, title := range titles {
+		doc := models.NewDocument(uint32(i+1), title, articleText("a place in france"), "", idx.build.Language())
+		for _, target := range out[title] {
+			doc.Links = append(doc.Links, models.Link{Target: target})
+		}
+		// self links and repeats don't count
+		doc.Links = append(doc.Links, models.Link{Target: title})
+		if len(out[title]) > 0 {
+			doc.Links = append(doc.Links, models.Link{Target: out[title][0]})
+		}
+		docs = append(docs, doc)
+	}
+	flushDocs(t, idx, docs...)
+
+	if err := idx.resolveLinks(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.computePageRank(); err != nil {
+		t.Fatal(err)
+	}
+	saved := savedDocs(t, idx)
+
+	want := referenceRanks(out, titles)
+	total := 0.0
+	for _, doc := range saved {
+		total += doc.PageRank
+		if math.Abs(doc.PageRank-want[doc.Title]) > 1e-4 {
+			t.Errorf("%s pagerank %v, want %v", doc.Title, doc.PageRank, want[doc.Title])
+		}
+	}
+	if math.Abs(total/float64(len(titles))-1) > 1e-9 {
+		t.Errorf("average pagerank %v, want 1", total/float64(len(titles)))
+	}
+
+	best, worst := saved["Paris"], saved["Loire"]
+	for _, doc := range saved {
+		if doc.PageRank > best.PageRank || doc.PageRank < worst.PageRank {
+			t.Errorf("Paris %v and Loire %v aren't the ends, %s has %v", best.PageRank, worst.PageRank, doc.Title, doc.PageRank)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package indexer
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// IsPageviewDump matches the files published under
+// dumps.wikimedia.org/other/pageviews, hourly pageviews-20240101-000000.gz
+// or monthly pageviews-202401-user.bz2, and the older pagecounts-*
+func IsPageviewDump(filename string) bool {
+	base := filepath.Base(filename)
+	return strings.HasPrefix(base, "pageviews-") || strings.HasPrefix(base, "pagecounts-")
+}
+
+// viewRequest is a title's views in one dump, waiting for the title to be
+// looked up
+type viewRequest struct {
+	Key   string
+	Views int64
+}
+
+func viewRequestBefore(a, b viewRequest) bool {
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	return a.Views < b.Views
+}
+
+// viewRecord is views of the document ID, mergeSegments adds them up
+type viewRecord struct {
+	ID    uint32
+	Views int64
+}
+
+func viewBefore(a, b viewRecord) bool {
+	return a.ID < b.ID
+}
+
+// ImportPageviews adds up the views of every indexed article over the given
+// dumps and stores them as the document's popularity. views of redirects
+// count for their target. run it after ImportSQL so its redirects are known
+func (idx *Indexer) ImportPageviews(files []string) error {
+	tables, err := idx.lookups()
+	if err != nil {
+		return err
+	}
+
+	project := idx.build.Language().Code + ".wikipedia"
+	redirects := idx.build.Redirects.Map()
+	requests := &spill[viewRequest]{
+		dir:    idx.segmentDir(),
+		name:   "view-titles",
+		less:   viewRequestBefore,
+		size:   func(r viewRequest) int64 { return int64(len(r.Key)) + 32 },
+		budget: idx.budget,
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, max(idx.workers, 1))
+
+	for _, file := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(file string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			fmt.Printf("Importing %s\n", file)
+			counts, err := readPageviews(file, project)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", file, err)
+				}
+				return
+			}
+			for title, n := range counts {
+				if firstErr != nil {
+					return
+				}
+				firstErr = requests.add(viewRequest{Key: models.TitleKey(resolveRedirect(redirects, title)), Views: n})
+			}
+		}(file)
+	}
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = requests.flush()
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	views := &spill[viewRecord]{
+		dir:    idx.segmentDir(),
+		name:   "views",
+		less:   viewBefore,
+		size:   func(viewRecord) int64 { return 16 },
+		budget: idx.budget,
+	}
+	titles := 0
+	err = lookup(tables.titles, requests.paths, viewRequestBefore, func(r viewRequest) string { return r.Key }, func(r viewRequest, id uint32) error {
+		titles++
+		return views.add(viewRecord{ID: id, Views: r.Views})
+	})
+	if err == nil {
+		err = views.flush()
+	}
+	if err == nil {
+		err = removeRuns(requests.paths)
+	}
+	idx.viewRuns = views.paths
+
+	fmt.Printf("Pageviews for %d titles\n", titles)
+	return err
+}
+
+// readPageviews sums views per title for one project. lines are
+// "domain title views ..." in hourly files and
+// "domain title page_id access daily_total ..." in monthly ones
+func readPageviews(file, project string) (map[string]int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	switch {
+	case strings.HasSuffix(file, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	case strings.HasSuffix(file, ".bz2"):
+		reader = bzip2.NewReader(f)
+	}
+
+	counts := make(map[string]int64)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || pageviewProject(fields[0]) != project {
+			continue
+		}
+
+		column := 2
+		if len(fields) >= 5 && isAccessMethod(fields[3]) {
+			column = 4
+		}
+		n, err := strconv.ParseInt(fields[column], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		title := fields[1]
+		if strings.Contains(title, "%") {
+			if unescaped, err := url.PathUnescape(title); err == nil {
+				title = unescaped
+			}
+		}
+		counts[strings.ReplaceAll(title, "_", " ")] += n
+	}
+
+	return counts, scanner.Err()
+}
+
+// pageviewProject normalizes domain codes, "en" and "en.m" are
+// en.wikipedia desktop and mobile, "en.b" is wikibooks and so on
+func pageviewProject(domain string) string {
+	parts := strings.Split(domain, ".")
+	if len(parts) > 1 && parts[1] == "m" {
+		parts = append(parts[:1], parts[2:]...)
+	}
+
+	switch {
+	case len(parts) == 1:
+		return parts[0] + ".wikipedia"
+	case len(parts) == 2 && parts[1] == "wikipedia":
+		return parts[0] + ".wikipedia"
+	}
+
+	return domain
+}
+
+func isAccessMethod(field string) bool {
+	return field == "desktop" || field == "mobile-web" || field == "mobile-app"
+}
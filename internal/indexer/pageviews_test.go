@@ -0,0 +1,44 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+func TestImportPageviews(t *testing.T) {
+	hourly := "en Paris 120 0\n" +
+		"en.m Paris 30 0\n" +
+		"en City_of_Light 5 0\n" +
+		"en Caf%C3%A9 7 0\n" +
+		"de Paris 1000 0\n" +
+		"en.b Paris 1000 0\n" +
+		"en Lyon notanumber 0\n" +
+		"en Missing 50 0\n"
+	monthly := "en.wikipedia Lyon 2 desktop 40 A40\n" +
+		"en.wikipedia Paris 1 mobile-web 100 A100\n" +
+		"fr.wikipedia Lyon 3 desktop 900 A900\n"
+
+	idx := NewIndexer(t.TempDir(), 2, Options{})
+	var docs []*models.Document
+	for i, title := range []string{"Paris", "Lyon", "Café", "Nice"} {
+		docs = append(docs, models.NewDocument(uint32(i+1), title, articleText("a place in france"), "", idx.build.Language()))
+	}
+	flushDocs(t, idx, docs...)
+	idx.build.Redirects.Add("City of Light", "Paris")
+
+	err := idx.ImportPageviews([]string{
+		writeDump(t, "pageviews-20240101-000000.gz", hourly),
+		writeDump(t, "pageviews-202401-user", monthly),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int64{"Paris": 255, "Lyon": 40, "Café": 7, "Nice": 0}
+	for _, doc := range savedDocs(t, idx) {
+		if doc.Popularity != want[doc.Title] {
+			t.Errorf("%s has %d views, want %d", doc.Title, doc.Popularity, want[doc.Title])
+		}
+	}
+}
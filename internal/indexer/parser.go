@@ -3,38 +3,83 @@ package indexer
 import (
 	"compress/bzip2"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
-	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/wikitext"
 )
 
 type Redirect struct {
 	Title string `xml:"title,attr"`
 }
 
+type Contributor struct {
+	Username string `xml:"username"`
+	ID       int64  `xml:"id"`
+	IP       string `xml:"ip"` // anonymous edits only have this
+}
+
 type WikiPage struct {
-	Title    string   `xml:"title"`
-	ID       int64    `xml:"id"`
-	Redirect Redirect `xml:"redirect"`
-	Text     string   `xml:"revision>text"`
+	Title       string      `xml:"title"`
+	NS          *int        `xml:"ns"` // nil when the dump has no <ns>
+	ID          int64       `xml:"id"`
+	Redirect    Redirect    `xml:"redirect"`
+	RevisionID  int64       `xml:"revision>id"`
+	Timestamp   string      `xml:"revision>timestamp"`
+	Contributor Contributor `xml:"revision>contributor"`
+	Comment     string      `xml:"revision>comment"`
+	Text        string      `xml:"revision>text"`
+}
+
+type Namespace struct {
+	Key  int    `xml:"key,attr"`
+	Case string `xml:"case,attr"`
+	Name string `xml:",chardata"`
+}
+
+type SiteInfo struct {
+	SiteName   string      `xml:"sitename"`
+	DBName     string      `xml:"dbname"`
+	Base       string      `xml:"base"`
+	Generator  string      `xml:"generator"`
+	Case       string      `xml:"case"`
+	Namespaces []Namespace `xml:"namespaces>namespace"`
 }
 
 type Parser struct {
-	docChan chan<- *models.Document
-	docID   uint32
+	docChan    chan<- *models.Document
+	build      *Build
+	namespaces map[int]bool
+	siteInfo   *SiteInfo
+	wiki       *wikitext.Parser
+	urlBase    string
 }
 
-func NewParser(docChan chan<- *models.Document) *Parser {
+func NewParser(docChan chan<- *models.Document, build *Build) *Parser {
+	namespaces := make(map[int]bool)
+	for _, ns := range build.Options.namespaces() {
+		namespaces[ns] = true
+	}
+
 	return &Parser{
-		docChan: docChan,
-		docID:   0,
+		docChan:    docChan,
+		build:      build,
+		namespaces: namespaces,
+		wiki:       wikitext.NewParser(nil),
+		urlBase:    build.URLBase(),
 	}
 }
 
+func (p *Parser) SiteInfo() *SiteInfo {
+	return p.siteInfo
+}
+
 func (p *Parser) ParseFile(filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -49,93 +94,303 @@ func (p *Parser) ParseFile(filename string) error {
 		reader = bzip2.NewReader(file)
 	}
 
+	return p.parse(reader, filename, false)
+}
+
+// parse reads pages off an xml stream, skipping the ones a resumed build
+// already has. key names the stream for checkpoints, "" leaves it untracked.
+// a fragment is one stream of a multistream dump, which starts or ends
+// halfway through <mediawiki>, see fragmentEnd
+func (p *Parser) parse(reader io.Reader, key string, fragment bool) error {
+	progress := p.build.progress
+
+	resume := int64(0)
+	if key != "" {
+		resume = progress.offset(key)
+	}
+	if resume == streamDone {
+		return nil
+	}
+
 	decoder := xml.NewDecoder(reader)
 
 	for {
 		token, err := decoder.Token()
-		if err == io.EOF {
+		if err == io.EOF || fragment && fragmentEnd(err) {
+			progress.begin()
+			progress.end(key, streamDone)
+			return nil
+		}
+		if err != nil && fragment {
+			// the other streams are fine. the skip and the end of the stream
+			// go in the same checkpoint, or a resumed build would parse the
+			// stream again and count the skip twice
+			fmt.Printf("Skipping the rest of stream %s: %v\n", key, err)
+			progress.begin()
+			p.build.skip(Skip{Reason: SkipMalformed, File: key, Offset: decoder.InputOffset(), Error: err.Error()})
+			progress.end(key, streamDone)
 			return nil
 		}
 		if err != nil {
 			return err
 		}
-		
+
 		switch se := token.(type) {
 		case xml.StartElement:
-			if se.Name.Local == "page" {
-				var page WikiPage
+			if se.Name.Local == "siteinfo" {
+				var info SiteInfo
+				if err := decoder.DecodeElement(&info, &se); err != nil {
+					return fmt.Errorf("decoding siteinfo: %w", err)
+				}
+				p.setSiteInfo(&info)
+			}
 
-				if err := decoder.DecodeElement(&page, &se); err != nil {
-					continue //skippin malformed pages
+			if se.Name.Local == "page" {
+				// -max-docs reached, the rest of the stream isn't needed
+				if p.build.full() {
+					return nil
 				}
 
-				if p.shouldIndex(&page) {
-					doc := p.createDocument(&page)
-					if doc != nil {
-						p.docChan <- doc
+				// done before the checkpoint
+				if decoder.InputOffset() < resume {
+					if err := decoder.Skip(); err != nil {
+						return err
 					}
+					continue
+				}
+
+				progress.begin()
+				if !p.handlePage(decoder, &se, key) {
+					// another reader took the last document -max-docs allows
+					progress.end("", 0)
+					return nil
 				}
+				progress.end(key, decoder.InputOffset())
 			}
 		}
 	}
+}
+
+// fragmentEnd tells the errors a multistream fragment ends with from real
+// ones: the first stream stops inside <mediawiki>, the last one closes it
+func fragmentEnd(err error) bool {
+	var syntaxErr *xml.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return false
+	}
+
+	return syntaxErr.Msg == "unexpected EOF" || syntaxErr.Msg == "unexpected end element </mediawiki>"
+}
+
+// handlePage is false when -max-docs refused the page, it's left unread
+func (p *Parser) handlePage(decoder *xml.Decoder, se *xml.StartElement, key string) bool {
+	var page WikiPage
+
+	offset := decoder.InputOffset()
+	if err := decoder.DecodeElement(&page, se); err != nil {
+		// whatever decoded before the error, usually the title
+		p.build.skip(Skip{Reason: SkipMalformed, File: key, Offset: offset, Title: page.Title, Error: err.Error()})
+		return true
+	}
 
-	return nil
+	if page.Redirect.Title != "" {
+		p.collectRedirect(&page)
+	}
+
+	skip := Skip{File: key, Offset: offset, Title: page.Title, PageID: page.ID}
+	if skip.Reason = p.skipReason(&page); skip.Reason != "" {
+		p.build.skip(skip)
+		return true
+	}
+	if ok, full := p.build.admit(skip); !ok {
+		return !full
+	}
+
+	doc, err := p.cleanPage(&page)
+	if doc == nil {
+		p.build.release()
+		skip.Reason = SkipCleanText
+		if err != nil {
+			skip.Error = err.Error()
+		}
+		p.build.skip(skip)
+		return true
+	}
+
+	p.build.send(p.docChan, doc, key, offset)
+
+	return true
 }
 
-func (p *Parser) shouldIndex(page *WikiPage) bool {
+// skipReason says why a page won't be indexed, "" when it will
+func (p *Parser) skipReason(page *WikiPage) string {
 	if page.Redirect.Title != "" { //skippin redirects
-		return false
+		return SkipRedirect
 	}
 
-	// skip special pages
-	if strings.HasPrefix(page.Title, "File:") || strings.HasPrefix(page.Title, "Category:") || strings.HasPrefix(page.Title, "Template:") || strings.HasPrefix(page.Title, "Wikipedia:") || strings.HasPrefix(page.Title, "User:") || strings.HasPrefix(page.Title, "Talk:") {
-		return false
+	// skip namespaces that aren't allowed
+	if !p.namespaces[p.namespaceOf(page)] {
+		return SkipNamespace
 	}
 
 	// must've have content
 	if len(strings.TrimSpace(page.Text)) < 100 {
-		return false
+		return SkipTooShort
 	}
 
-	return true
+	return ""
 }
 
-func (p *Parser) createDocument(page *WikiPage) *models.Document {
-	p.docID++
+// cleanPage turns a page into a document, nil when the cleaned text is too
+// short. a page that trips up the wikitext parser costs that page only
+func (p *Parser) cleanPage(page *WikiPage) (doc *models.Document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			doc, err = nil, fmt.Errorf("cleaning wikitext: %v", r)
+		}
+	}()
 
+	return p.createDocument(page), nil
+}
+
+// redirects become aliases of their target once every file is parsed
+func (p *Parser) collectRedirect(page *WikiPage) {
+	if !p.namespaces[p.namespaceOf(page)] {
+		return
+	}
+
+	target, _, _ := strings.Cut(page.Redirect.Title, "#")
+	if target = wikitext.NormalizeTitle(target); target != "" {
+		p.build.Redirects.Add(page.Title, target)
+	}
+}
+
+func (p *Parser) setSiteInfo(info *SiteInfo) {
+	names := make(map[string]int, len(info.Namespaces))
+	for _, ns := range info.Namespaces {
+		names[ns.Name] = ns.Key
+	}
+
+	p.siteInfo = info
+	p.wiki = wikitext.NewParser(names)
+
+	code, urlBase := info.Language()
+	p.build.detectLanguage(code, urlBase)
+	if p.build.Options.Language == "" && urlBase != "" {
+		p.urlBase = urlBase
+	}
+}
+
+// Language works out the wiki language and article url prefix from
+// <dbname> and <base>, e.g. "dewiki" and https://de.wikipedia.org/wiki/...
+func (info *SiteInfo) Language() (string, string) {
+	code := ""
+	if strings.HasSuffix(info.DBName, "wiki") {
+		code = strings.TrimSuffix(info.DBName, "wiki")
+	}
+
+	urlBase := ""
+	if u, err := url.Parse(info.Base); err == nil && u.Host != "" {
+		if i := strings.Index(u.Path, "/wiki/"); i >= 0 {
+			urlBase = u.Scheme + "://" + u.Host + u.Path[:i+len("/wiki/")]
+		}
+		if host := strings.Split(u.Host, "."); code == "" && len(host) > 2 {
+			code = host[0]
+		}
+	}
+
+	return code, urlBase
+}
+
+// namespaceOf trusts <ns> but falls back to the title prefix for old dumps
+// that don't carry it. <ns>0</ns> is an article whatever its title says
+func (p *Parser) namespaceOf(page *WikiPage) int {
+	if page.NS != nil {
+		return *page.NS
+	}
+
+	ns, _ := p.wiki.Namespace(page.Title)
+	return ns
+}
+
+func (p *Parser) createDocument(page *WikiPage) *models.Document {
 	// clean the content
-	content := p.cleanWikiText(page.Text)
+	parsed := p.wiki.Parse(page.Text)
+	content := parsed.Text
 	if len(content) < 50 {
 		return nil
 	}
 
-	url := fmt.Sprintf("https://en.wikipedia.org/wiki/%s", strings.ReplaceAll(page.Title, " ", "_"))
+	url := p.urlBase + strings.ReplaceAll(page.Title, " ", "_")
+
+	doc := models.NewDocument(p.build.IDs.Next(), page.Title, content, url, p.build.Language())
+	doc.PageID = page.ID
+	doc.RevisionID = page.RevisionID
+	doc.Contributor = page.Contributor.Username
+	if doc.Contributor == "" {
+		doc.Contributor = page.Contributor.IP
+	}
+	doc.Comment = page.Comment
+	if modified, err := time.Parse(time.RFC3339, page.Timestamp); err == nil {
+		doc.Modified = modified
+	}
+	doc.Sections = sectionsOf(parsed)
+	doc.Categories = categoriesOf(parsed)
+	doc.Infobox = infoboxOf(parsed)
+	doc.Links = linksOf(parsed)
+	markDisambiguation(doc, isDisambiguation(parsed, page.Text))
 
-	return models.NewDocument(p.docID, page.Title, content, url)
+	return doc
 }
 
-func (p *Parser) cleanWikiText(text string) string {
-	re := regexp.MustCompile(`\{\{[^}]*\}\}`)
-	text = re.ReplaceAllString(text, "")
+// categoriesOf collects the [[Category:...]] memberships in page order
+func categoriesOf(parsed *wikitext.Page) []string {
+	var categories []string
+	seen := make(map[string]bool)
 
-	re = regexp.MustCompile(`\[\[[^|\]]*\|([^\]]*)\]\]`)
-	text = re.ReplaceAllString(text, "$1")
+	for _, link := range parsed.Links {
+		if link.Namespace == wikitext.NSCategory && link.Target != "" && !seen[link.Target] {
+			seen[link.Target] = true
+			categories = append(categories, link.Target)
+		}
+	}
 
-	re = regexp.MustCompile(`\[\[([^\]]*)\]\]`)
-	text = re.ReplaceAllString(text, "$1")
+	return categories
+}
 
-	re = regexp.MustCompile(`\[[^\]]*\]`)
-	text = re.ReplaceAllString(text, "")
+// linksOf keeps the article links, the graph gets built from them once
+// every page is in
+func linksOf(parsed *wikitext.Page) []models.Link {
+	var links []models.Link
+	for _, link := range parsed.Links {
+		if link.Namespace == wikitext.NSMain && link.Target != "" {
+			links = append(links, models.Link{Target: link.Target, Label: link.Label})
+		}
+	}
 
-	re = regexp.MustCompile(`<[^>]*>`)
-	text = re.ReplaceAllString(text, "")
+	return links
+}
+
+// sectionsOf splits the parsed text at its headings, starting with the lead
+func sectionsOf(parsed *wikitext.Page) []models.Section {
+	sections := []models.Section{{Start: 0, End: len(parsed.Text)}}
 
-	re = regexp.MustCompile(`&[a-zA-Z]+;`)
-	text = re.ReplaceAllString(text, "")
+	for _, heading := range parsed.Headings {
+		last := &sections[len(sections)-1]
+		last.End = max(heading.Offset-1, last.Start) // drop the newline
 
-	// removin extra whitespace
-	re = regexp.MustCompile(`\s+`)
-	text = re.ReplaceAllString(text, " ")
+		sections = append(sections, models.Section{
+			Heading: heading.Title,
+			Level:   heading.Level,
+			Start:   heading.Offset,
+			End:     len(parsed.Text),
+		})
+	}
+
+	// articles that open with a heading have no lead
+	if sections[0].End == 0 {
+		sections = sections[1:]
+	}
 
-	return strings.TrimSpace(text)
+	return sections
 }
@@ -0,0 +1,112 @@
+package indexer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+const siteInfo = `<siteinfo><dbname>enwiki</dbname><base>https://en.wikipedia.org/wiki/Main_Page</base>
+<namespaces><namespace key="0" /><namespace key="1">Talk</namespace><namespace key="4">Wikipedia</namespace></namespaces></siteinfo>`
+
+// page writes a <page>, ns < 0 leaves out <ns> like old dumps do
+func page(title string, ns int, id int64, text string) string {
+	nsElement := ""
+	if ns >= 0 {
+		nsElement = fmt.Sprintf("<ns>%d</ns>", ns)
+	}
+
+	return fmt.Sprintf("<page><title>%s</title>%s<id>%d</id><revision><id>%d</id><text>%s</text></revision></page>\n",
+		title, nsElement, id, id*10, text)
+}
+
+// articleText is long enough for any page to be indexed
+func articleText(words ...string) string {
+	return strings.Repeat(strings.Join(words, " ")+" ", 20)
+}
+
+// parseDump runs the xml parser over a whole dump and returns what it sent
+func parseDump(t *testing.T, build *Build, dump string) []*models.Document {
+	t.Helper()
+
+	docChan := make(chan *models.Document, 100)
+	err := NewParser(docChan, build).parse(strings.NewReader(dump), "", false)
+	close(docChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var docs []*models.Document
+	for doc := range docChan {
+		docs = append(docs, doc)
+	}
+
+	return docs
+}
+
+func TestNamespaces(t *testing.T) {
+	dump := "<mediawiki>" + siteInfo +
+		page("Talk:Show", 0, 1, articleText("an article whose title looks like a talk page")) +
+		page("Talk:Paris", 1, 2, articleText("a real talk page")) +
+		page("Wikipedia:About", -1, 3, articleText("an old dump without ns")) +
+		page("Paris", -1, 4, articleText("an article of an old dump")) +
+		"</mediawiki>"
+
+	tests := []struct {
+		namespaces []int
+		want       []string
+	}{
+		{nil, []string{"Talk:Show", "Paris"}},
+		{[]int{1}, []string{"Talk:Paris"}},
+		{[]int{4}, []string{"Wikipedia:About"}},
+	}
+
+	for _, tt := range tests {
+		build := NewBuild(Options{Namespaces: tt.namespaces})
+
+		var titles []string
+		for _, doc := range parseDump(t, build, dump) {
+			titles = append(titles, doc.Title)
+		}
+		if !slices.Equal(titles, tt.want) {
+			t.Errorf("namespaces %v: indexed %q, want %q", tt.namespaces, titles, tt.want)
+		}
+	}
+}
+
+// a stream that breaks between pages is skipped once, however often the
+// build is resumed
+func TestBrokenFragmentSkippedOnce(t *testing.T) {
+	fragment := page("Paris", 0, 1, articleText("capital of france")) + "</broken>" +
+		page("Lyon", 0, 2, articleText("a city on the rhone"))
+
+	build := NewBuild(Options{})
+	for run := 0; run < 2; run++ {
+		docChan := make(chan *models.Document, 10)
+		if err := NewParser(docChan, build).parse(strings.NewReader(fragment), "dump@0", true); err != nil {
+			t.Fatal(err)
+		}
+		close(docChan)
+
+		var titles []string
+		for doc := range docChan {
+			titles = append(titles, doc.Title)
+		}
+		if want := []string{"Paris"}; run == 0 && !slices.Equal(titles, want) {
+			t.Errorf("indexed %q, want %q", titles, want)
+		}
+		if run == 1 && len(titles) > 0 {
+			t.Errorf("resumed build indexed %q again", titles)
+		}
+	}
+
+	if n := build.skipped()[SkipMalformed]; n != 1 {
+		t.Errorf("%d malformed skips, want 1", n)
+	}
+	if offset := build.progress.offset("dump@0"); offset != streamDone {
+		t.Errorf("stream left at offset %d, want it done", offset)
+	}
+}
@@ -0,0 +1,166 @@
+package indexer
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/storage"
+)
+
+// parsers take ids from one counter as they go, so which page gets which
+// id depends on how the files and streams interleaved. once parsing is
+// over renumber hands them out again in input order, and a resumed build
+// ends up with the same ids as one that ran through
+
+// position is where a document was read, see inputBefore
+type position struct {
+	Source string
+	Offset int64
+	ID     uint32
+}
+
+// inputBefore orders documents by the stream they were read from and their
+// offset in it
+func inputBefore(a, b position) bool {
+	return cmp.Or(
+		cmp.Compare(a.Source, b.Source),
+		cmp.Compare(a.Offset, b.Offset),
+		cmp.Compare(a.ID, b.ID),
+	) < 0
+}
+
+// renumber gives the documents ids in input order, after the ones the
+// index an update applies to has taken, and rewrites whatever already has
+// the parse time ids: postings, page ids and flushed segments. the
+// positions are spilled like everything else, what it holds is the new id
+// of every old one
+func (idx *Indexer) renumber() error {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	if err := os.MkdirAll(idx.segmentDir(), 0755); err != nil {
+		return err
+	}
+	positions := &spill[position]{
+		dir:    idx.segmentDir(),
+		name:   "positions",
+		less:   inputBefore,
+		size:   func(p position) int64 { return int64(len(p.Source)) + 40 },
+		budget: idx.budget,
+	}
+	add := func(doc *models.Document) error {
+		return positions.add(position{Source: doc.Source, Offset: doc.SourceOffset, ID: doc.ID})
+	}
+	for _, doc := range idx.documents {
+		if err := add(doc); err != nil {
+			return err
+		}
+	}
+	if err := idx.eachDocument(add); err != nil {
+		return err
+	}
+	if err := positions.flush(); err != nil {
+		return err
+	}
+
+	first := uint32(0)
+	if idx.base != nil {
+		first = idx.base.nextID
+	}
+
+	remap := make([]uint32, idx.build.IDs.next.Load()+1)
+	next, changed := first, false
+	err := eachMerged(positions.paths, inputBefore, func(p position) error {
+		next++
+		remap[p.ID] = next
+		changed = changed || p.ID != next
+		return nil
+	})
+	if err == nil {
+		err = removeRuns(positions.paths)
+	}
+	// an update has no segments, the directory was only for the positions
+	if err == nil && idx.segments == 0 {
+		err = os.Remove(idx.segmentDir())
+	}
+	if err != nil {
+		return err
+	}
+	idx.build.IDs.next.Store(next)
+
+	if !changed {
+		return nil
+	}
+
+	documents := idx.documents
+	idx.documents = make(map[uint32]*models.Document, len(documents))
+	idx.pageIDs = make(map[int64]uint32, len(idx.pageIDs))
+	for _, doc := range documents {
+		doc.ID = remap[doc.ID]
+		idx.documents[doc.ID] = doc
+	}
+	for _, doc := range idx.documents {
+		// a page that's in the input twice keeps the copy read last
+		if doc.PageID != 0 && doc.ID > idx.pageIDs[doc.PageID] {
+			idx.pageIDs[doc.PageID] = doc.ID
+		}
+	}
+
+	for _, postings := range idx.termIndex {
+		for i, id := range postings {
+			postings[i] = remap[id]
+		}
+	}
+
+	if idx.segments == 0 {
+		return nil
+	}
+
+	// a checkpoint from before would pair the old ids with the new segments
+	if err := idx.storage.RemoveCheckpoint(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Renumbering %d segments...\n", idx.segments)
+	for _, path := range idx.segmentFiles("docs") {
+		err := rewriteRun(path, docBefore, func(doc *models.Document) {
+			doc.ID = remap[doc.ID]
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, path := range idx.segmentFiles("terms") {
+		err := rewriteRun(path, postingBefore, func(posting storage.Posting) {
+			for i, id := range posting.Docs {
+				posting.Docs[i] = remap[id]
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	idx.tables = nil
+
+	return nil
+}
+
+// rewriteRun passes every value of a run through fn and writes it back in
+// order. a run is never bigger than the budget it was flushed under
+func rewriteRun[T any](path string, less func(a, b T) bool, fn func(v T)) error {
+	values, err := readRun[T](path)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		fn(v)
+	}
+
+	if err := writeRun(path+".tmp", values, less); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,299 @@
+package indexer
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// a run is a file of gob values in sorted order. segmented builds write
+// them instead of holding everything, then merge them back k ways
+
+// writeRun sorts values and writes them to path
+func writeRun[T any](path string, values []T, less func(a, b T) bool) error {
+	sort.Slice(values, func(i, j int) bool { return less(values[i], values[j]) })
+
+	w, err := createRun[T](path)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if err := w.write(v); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+
+	return w.close()
+}
+
+// runWriter writes a run a value at a time, for values that come out of
+// a merge already sorted
+type runWriter[T any] struct {
+	file    *os.File
+	buf     *bufio.Writer
+	encoder *gob.Encoder
+}
+
+func createRun[T any](path string) (*runWriter[T], error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriterSize(file, 1<<20)
+	return &runWriter[T]{file: file, buf: buf, encoder: gob.NewEncoder(buf)}, nil
+}
+
+func (w *runWriter[T]) write(v T) error {
+	return w.encoder.Encode(v)
+}
+
+func (w *runWriter[T]) close() error {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// runReader reads one run a value at a time, head is the next value
+type runReader[T any] struct {
+	file    *os.File
+	decoder *gob.Decoder
+	head    T
+	done    bool
+}
+
+func openRun[T any](path string) (*runReader[T], error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &runReader[T]{file: file, decoder: gob.NewDecoder(bufio.NewReaderSize(file, 1<<20))}
+	if err := r.advance(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+
+	return r, nil
+}
+
+func (r *runReader[T]) advance() error {
+	var v T
+	err := r.decoder.Decode(&v)
+	if errors.Is(err, io.EOF) {
+		r.done = true
+		return nil
+	}
+	r.head = v
+
+	return err
+}
+
+// runMerger hands out the values of several runs in one sorted order
+type runMerger[T any] struct {
+	runs []*runReader[T]
+	less func(a, b T) bool
+}
+
+func (m *runMerger[T]) Len() int           { return len(m.runs) }
+func (m *runMerger[T]) Less(i, j int) bool { return m.less(m.runs[i].head, m.runs[j].head) }
+func (m *runMerger[T]) Swap(i, j int)      { m.runs[i], m.runs[j] = m.runs[j], m.runs[i] }
+func (m *runMerger[T]) Push(x any)         { m.runs = append(m.runs, x.(*runReader[T])) }
+
+func (m *runMerger[T]) Pop() any {
+	r := m.runs[len(m.runs)-1]
+	m.runs = m.runs[:len(m.runs)-1]
+	return r
+}
+
+func mergeRuns[T any](paths []string, less func(a, b T) bool) (*runMerger[T], error) {
+	m := &runMerger[T]{less: less}
+	for _, path := range paths {
+		r, err := openRun[T](path)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+
+		if r.done {
+			r.file.Close()
+			continue
+		}
+		m.runs = append(m.runs, r)
+	}
+	heap.Init(m)
+
+	return m, nil
+}
+
+// peek returns the next value without taking it
+func (m *runMerger[T]) peek() (T, bool) {
+	if len(m.runs) == 0 {
+		var zero T
+		return zero, false
+	}
+
+	return m.runs[0].head, true
+}
+
+func (m *runMerger[T]) next() (T, bool, error) {
+	v, ok := m.peek()
+	if !ok {
+		return v, false, nil
+	}
+
+	r := m.runs[0]
+	if err := r.advance(); err != nil {
+		return v, false, err
+	}
+	if r.done {
+		r.file.Close()
+		heap.Pop(m)
+	} else {
+		heap.Fix(m, 0)
+	}
+
+	return v, true, nil
+}
+
+func (m *runMerger[T]) close() {
+	if m == nil {
+		return
+	}
+	for _, r := range m.runs {
+		r.file.Close()
+	}
+	m.runs = nil
+}
+
+// spill collects values and writes them out as runs whenever they pass
+// the budget
+type spill[T any] struct {
+	dir    string
+	name   string
+	less   func(a, b T) bool
+	size   func(v T) int64
+	budget int64
+
+	values []T
+	used   int64
+	paths  []string
+}
+
+func (s *spill[T]) add(v T) error {
+	s.values = append(s.values, v)
+	s.used += s.size(v)
+
+	if s.budget > 0 && s.used >= s.budget {
+		return s.flush()
+	}
+
+	return nil
+}
+
+func (s *spill[T]) flush() error {
+	if len(s.values) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%s-%06d.gob", s.name, len(s.paths)+1))
+	if err := writeRun(path, s.values, s.less); err != nil {
+		return err
+	}
+
+	s.paths = append(s.paths, path)
+	s.values, s.used = nil, 0
+
+	return nil
+}
+
+// readRun reads a whole run back
+func readRun[T any](path string) ([]T, error) {
+	var values []T
+	err := eachValue(path, func(v T) error {
+		values = append(values, v)
+		return nil
+	})
+
+	return values, err
+}
+
+// eachValue calls fn with the values of a run in order
+func eachValue[T any](path string, fn func(v T) error) error {
+	r, err := openRun[T](path)
+	if err != nil {
+		return err
+	}
+	defer r.file.Close()
+
+	for !r.done {
+		if err := fn(r.head); err != nil {
+			return err
+		}
+		if err := r.advance(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eachMerged calls fn with the values of several runs in one sorted order
+func eachMerged[T any](paths []string, less func(a, b T) bool, fn func(v T) error) error {
+	m, err := mergeRuns(paths, less)
+	if err != nil {
+		return err
+	}
+	defer m.close()
+
+	for {
+		v, ok, err := m.next()
+		if err != nil || !ok {
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+}
+
+// takeFor calls fn with the values of a merge in doc id order up to id,
+// dropping the ones of ids before it. the stages after parsing leave their
+// results in such runs, read alongside the documents
+func takeFor[T any](m *runMerger[T], id uint32, idOf func(v T) uint32, fn func(v T) error) error {
+	for {
+		v, ok := m.peek()
+		if !ok || idOf(v) > id {
+			return nil
+		}
+		if _, _, err := m.next(); err != nil {
+			return err
+		}
+		if idOf(v) == id {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// removeRuns deletes runs a stage is done with
+func removeRuns(paths []string) error {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
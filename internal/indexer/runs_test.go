@@ -0,0 +1,113 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func intBefore(a, b int) bool { return a < b }
+
+// a spill over its budget writes several runs, merged back in order
+func TestSpillMerge(t *testing.T) {
+	s := &spill[int]{
+		dir:    t.TempDir(),
+		name:   "numbers",
+		less:   intBefore,
+		size:   func(int) int64 { return 8 },
+		budget: 32,
+	}
+	for _, v := range []int{9, 3, 7, 1, 8, 2, 6, 4, 5, 0} {
+		if err := s.add(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.paths) != 3 {
+		t.Errorf("spilled %d runs, want 3", len(s.paths))
+	}
+
+	var got []int
+	err := eachMerged(s.paths, intBefore, func(v int) error {
+		got = append(got, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+}
+
+// takeFor skips the values of ids it's not asked for
+func TestTakeFor(t *testing.T) {
+	s := &spill[rankRecord]{dir: t.TempDir(), name: "ranks", less: rankBefore, size: func(rankRecord) int64 { return 16 }}
+	for _, r := range []rankRecord{{ID: 5, Rank: 5}, {ID: 1, Rank: 1}, {ID: 3, Rank: 3}, {ID: 3, Rank: 4}} {
+		if err := s.add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mergeRuns(s.paths, rankBefore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.close()
+
+	idOf := func(r rankRecord) uint32 { return r.ID }
+	for _, id := range []uint32{2, 3, 6} {
+		sum := 0.0
+		err := takeFor(m, id, idOf, func(r rankRecord) error {
+			sum += r.Rank
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[uint32]float64{3: 7}[id]; sum != want {
+			t.Errorf("id %d took %v, want %v", id, sum, want)
+		}
+	}
+}
+
+// a key in the table twice finds the higher id, keys it doesn't have are
+// skipped
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	table := &spill[keyRecord[string]]{dir: dir, name: "titles", less: keyBefore[string], size: func(keyRecord[string]) int64 { return 24 }, budget: 48}
+	for _, r := range []keyRecord[string]{{"paris", 2}, {"berlin", 1}, {"paris", 7}, {"rome", 4}} {
+		if err := table.add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := table.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := &spill[viewRequest]{dir: dir, name: "views", less: viewRequestBefore, size: func(viewRequest) int64 { return 24 }, budget: 48}
+	for _, r := range []viewRequest{{"rome", 1}, {"madrid", 2}, {"paris", 3}, {"berlin", 4}, {"paris", 5}} {
+		if err := requests.add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := requests.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[uint32]int64)
+	err := lookup(table.paths, requests.paths, viewRequestBefore, func(r viewRequest) string { return r.Key }, func(r viewRequest, id uint32) error {
+		got[id] += r.Views
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[uint32]int64{1: 4, 4: 1, 7: 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("looked up %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,473 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/storage"
+)
+
+// the indexer builds the index like spimi: parsed documents and their
+// postings are flushed to a segment on disk whenever they pass the memory
+// budget, and once more when parsing is done. nothing of a flushed document
+// stays in memory, the stages after parsing read the segments back and
+// leave what they found in sorted runs. SaveToDisk merges the segments and
+// the runs into the final files
+
+// SetMemoryBudget sets roughly how many bytes of documents and postings the
+// build holds before flushing a segment, and the stages after parsing
+// before spilling a run. 0 only flushes once parsing is done and never
+// spills, the index comes out the same either way
+func (idx *Indexer) SetMemoryBudget(bytes int64) {
+	idx.budget = bytes
+}
+
+func (idx *Indexer) segmentDir() string {
+	return filepath.Join(idx.indexPath, "segments")
+}
+
+// segmentFiles lists the docs or terms files of the flushed segments
+func (idx *Indexer) segmentFiles(kind string) []string {
+	paths := make([]string, idx.segments)
+	for i := range paths {
+		paths[i] = filepath.Join(idx.segmentDir(), fmt.Sprintf("%06d-%s.gob", i+1, kind))
+	}
+
+	return paths
+}
+
+// docMemory is a rough count of what a parsed document and its postings
+// hold on to until they're flushed
+func docMemory(doc *models.Document) int64 {
+	n := 256 + len(doc.Title) + len(doc.URL) + len(doc.Content)
+	for term := range doc.Terms {
+		n += 2*len(term) + 64 // the doc's map entry, the posting and maybe a new term
+	}
+	for _, link := range doc.Links {
+		n += len(link.Target) + len(link.Label) + 32
+	}
+	for _, category := range doc.Categories {
+		n += len(category) + 16
+	}
+	n += 48 * len(doc.Sections)
+	if doc.Infobox != nil {
+		for key, value := range doc.Infobox.Fields {
+			n += len(key) + len(value) + 64
+		}
+	}
+
+	return int64(n)
+}
+
+// flushSegment writes the documents held in memory sorted by id, and the
+// postings sorted by term. call with idx.mutex held
+func (idx *Indexer) flushSegment() error {
+	if len(idx.documents) == 0 {
+		return nil
+	}
+
+	// whatever an earlier build left behind isn't ours
+	if idx.segments == 0 {
+		if err := os.RemoveAll(idx.segmentDir()); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(idx.segmentDir(), 0755); err != nil {
+		return err
+	}
+
+	docs := make([]*models.Document, 0, len(idx.documents))
+	for _, doc := range idx.documents {
+		docs = append(docs, doc)
+	}
+
+	idx.segments++
+	err := writeRun(idx.segmentFiles("docs")[idx.segments-1], docs, docBefore)
+	if err == nil {
+		postings := make([]storage.Posting, 0, len(idx.termIndex))
+		for term, docs := range idx.termIndex {
+			postings = append(postings, storage.Posting{Term: term, Docs: docs})
+		}
+		err = writeRun(idx.segmentFiles("terms")[idx.segments-1], postings, postingBefore)
+	}
+	if err != nil {
+		idx.segments--
+		return fmt.Errorf("flushing segment: %w", err)
+	}
+
+	fmt.Printf("Flushed segment %d with %d documents and %d terms\n", idx.segments, len(docs), len(idx.termIndex))
+
+	idx.documents = make(map[uint32]*models.Document)
+	idx.termIndex = make(map[string][]uint32)
+	idx.pageIDs = make(map[int64]uint32)
+	idx.memory = 0
+	idx.tables = nil
+
+	return nil
+}
+
+// resumeSegments picks up the segments a checkpoint refers to and drops
+// the ones flushed after it
+func (idx *Indexer) resumeSegments(segments int) error {
+	idx.segments = segments
+	idx.memory = 0
+	for _, doc := range idx.documents {
+		idx.memory += docMemory(doc)
+	}
+
+	keep := make(map[string]bool)
+	for _, path := range append(idx.segmentFiles("docs"), idx.segmentFiles("terms")...) {
+		keep[filepath.Base(path)] = true
+	}
+
+	entries, err := os.ReadDir(idx.segmentDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !keep[entry.Name()] {
+			if err := os.Remove(filepath.Join(idx.segmentDir(), entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// eachDocument calls fn with every flushed document in id order. the
+// segments overlap once renumber ran, so they're merged
+func (idx *Indexer) eachDocument(fn func(doc *models.Document) error) error {
+	lang := idx.build.Language()
+	return eachMerged(idx.segmentFiles("docs"), docBefore, func(doc *models.Document) error {
+		doc.SetLanguage(lang)
+		return fn(doc)
+	})
+}
+
+// stageResults are the runs the stages after parsing left, open for one
+// pass in doc id order
+type stageResults struct {
+	anchors    *runMerger[anchorRecord]
+	aliases    *runMerger[aliasRecord]
+	categories *runMerger[categoryRecord]
+	views      *runMerger[viewRecord]
+	ranks      *runMerger[rankRecord]
+	clusters   *runMerger[clusterRecord]
+}
+
+func (idx *Indexer) openResults() (*stageResults, error) {
+	r := &stageResults{}
+	var err error
+	if r.anchors, err = mergeRuns(idx.anchorRuns, anchorBefore); err != nil {
+		return nil, err
+	}
+	if r.aliases, err = mergeRuns(idx.aliasRuns, aliasBefore); err != nil {
+		r.close()
+		return nil, err
+	}
+	if r.categories, err = mergeRuns(idx.categoryRuns, categoryBefore); err != nil {
+		r.close()
+		return nil, err
+	}
+	if r.views, err = mergeRuns(idx.viewRuns, viewBefore); err != nil {
+		r.close()
+		return nil, err
+	}
+	if r.ranks, err = mergeRuns(idx.rankRuns, rankBefore); err != nil {
+		r.close()
+		return nil, err
+	}
+	if r.clusters, err = mergeRuns(idx.clusterRuns, clusterBefore); err != nil {
+		r.close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *stageResults) close() {
+	for _, m := range []interface{ close() }{r.anchors, r.aliases, r.categories, r.views, r.ranks, r.clusters} {
+		m.close()
+	}
+}
+
+// addAliases indexes the redirects resolved to doc and calls fn with the
+// terms it didn't have before
+func (r *stageResults) addAliases(doc *models.Document, fn func(term string) error) error {
+	return takeFor(r.aliases, doc.ID, func(a aliasRecord) uint32 { return a.ID }, func(a aliasRecord) error {
+		if slices.Contains(doc.Aliases, a.Alias) {
+			return nil
+		}
+		for _, term := range doc.AddAlias(a.Alias) {
+			if err := fn(term); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// setPageRank takes doc's score from the pagerank run, a document keeps
+// its own when the build didn't compute any
+func (r *stageResults) setPageRank(doc *models.Document) error {
+	return takeFor(r.ranks, doc.ID, func(rank rankRecord) uint32 { return rank.ID }, func(rank rankRecord) error {
+		doc.PageRank = rank.Rank
+		return nil
+	})
+}
+
+// apply gives doc what every stage found for it, alias terms go to fn
+func (r *stageResults) apply(doc *models.Document, fn func(term string) error) error {
+	labels := make(map[string]int)
+	err := takeFor(r.anchors, doc.ID, func(a anchorRecord) uint32 { return a.Target }, func(a anchorRecord) error {
+		labels[a.Text]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(labels) > 0 {
+		doc.SetAnchors(sortAnchors(labels))
+	}
+
+	if err := r.addAliases(doc, fn); err != nil {
+		return err
+	}
+
+	// the sql dumps add to the categories of the text
+	err = takeFor(r.categories, doc.ID, func(c categoryRecord) uint32 { return c.ID }, func(c categoryRecord) error {
+		if !slices.Contains(doc.Categories, c.Category) {
+			doc.Categories = append(doc.Categories, c.Category)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := r.setPageRank(doc); err != nil {
+		return err
+	}
+
+	views, viewed := int64(0), false
+	err = takeFor(r.views, doc.ID, func(v viewRecord) uint32 { return v.ID }, func(v viewRecord) error {
+		views += v.Views
+		viewed = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if viewed {
+		doc.Popularity = views
+	}
+
+	return takeFor(r.clusters, doc.ID, func(c clusterRecord) uint32 { return c.ID }, func(c clusterRecord) error {
+		doc.Canonical = c.Canonical
+		return nil
+	})
+}
+
+// indexWriter writes the files of the index a document at a time
+type indexWriter struct {
+	docs, ids, lengths, titles *storage.StreamWriter
+}
+
+func (idx *Indexer) createIndex() (*indexWriter, error) {
+	w := &indexWriter{}
+	var err error
+	if w.docs, err = idx.storage.CreateDocumentStream(); err != nil {
+		return nil, err
+	}
+	if w.ids, err = idx.storage.CreateIDStream(); err == nil {
+		if w.lengths, err = idx.storage.CreateLengthStream(); err == nil {
+			w.titles, err = idx.storage.CreateTitleStream()
+		}
+	}
+	if err != nil {
+		w.close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *indexWriter) write(doc *models.Document) error {
+	if err := w.docs.Write(doc); err != nil {
+		return err
+	}
+	// a page that's in the input twice keeps the copy read last
+	if doc.PageID != 0 {
+		if err := w.ids.Write(storage.PageIDEntry{PageID: doc.PageID, ID: doc.ID}); err != nil {
+			return err
+		}
+	}
+	if err := w.lengths.Write(storage.LengthEntry{ID: doc.ID, Length: doc.Length}); err != nil {
+		return err
+	}
+
+	return w.titles.Write(storage.TitleEntry{Key: models.TitleKey(doc.Title), ID: doc.ID})
+}
+
+func (w *indexWriter) close() error {
+	var errs []error
+	for _, stream := range []*storage.StreamWriter{w.docs, w.ids, w.lengths, w.titles} {
+		if stream != nil {
+			errs = append(errs, stream.Close())
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// mergeSegments writes the index from the segments. the documents come
+// out in id order, which is also the order of the stage results, so every
+// document gets what the stages found for it one at a time. alias terms
+// are spilled and merged in with the segment postings
+func (idx *Indexer) mergeSegments() error {
+	fmt.Printf("Merging %d segments...\n", idx.segments)
+
+	docs, err := mergeRuns(idx.segmentFiles("docs"), docBefore)
+	if err != nil {
+		return err
+	}
+	defer docs.close()
+
+	results, err := idx.openResults()
+	if err != nil {
+		return err
+	}
+	defer results.close()
+
+	aliases := &spill[storage.Posting]{
+		dir:    idx.segmentDir(),
+		name:   "alias-terms",
+		less:   postingBefore,
+		size:   func(p storage.Posting) int64 { return int64(len(p.Term)) + 48 },
+		budget: idx.budget,
+	}
+
+	out, err := idx.createIndex()
+	if err != nil {
+		return err
+	}
+
+	lang := idx.build.Language()
+	count, totalLen := 0, 0
+	for {
+		doc, ok, err := docs.next()
+		if err != nil {
+			out.close()
+			return err
+		}
+		if !ok {
+			break
+		}
+		doc.SetLanguage(lang)
+
+		err = results.apply(doc, func(term string) error {
+			return aliases.add(storage.Posting{Term: term, Docs: []uint32{doc.ID}})
+		})
+		if err == nil {
+			doc.Source, doc.SourceOffset = "", 0
+			err = out.write(doc)
+		}
+		if err != nil {
+			out.close()
+			return err
+		}
+
+		count++
+		totalLen += doc.Length
+	}
+	if err := out.close(); err != nil {
+		return err
+	}
+	if err := aliases.flush(); err != nil {
+		return err
+	}
+
+	if err := idx.mergeTerms(append(idx.segmentFiles("terms"), aliases.paths...)); err != nil {
+		return err
+	}
+
+	idx.docCount = count
+	idx.avgDocLen = 0
+	if count > 0 {
+		idx.avgDocLen = float64(totalLen) / float64(count)
+	}
+	fmt.Printf("Total documents: %d\n", idx.docCount)
+	fmt.Printf("Total terms: %d\n", idx.termCount)
+	fmt.Printf("Average document length: %.2f\n", idx.avgDocLen)
+
+	return nil
+}
+
+// mergeTerms joins the postings of the same term across runs
+func (idx *Indexer) mergeTerms(paths []string) error {
+	terms, err := mergeRuns(paths, postingBefore)
+	if err != nil {
+		return err
+	}
+	defer terms.close()
+
+	out, err := idx.storage.CreateTermStream()
+	if err != nil {
+		return err
+	}
+
+	idx.termCount = 0
+	var current *storage.Posting
+	write := func() error {
+		if current == nil {
+			return nil
+		}
+		slices.Sort(current.Docs)
+		idx.termCount++
+		return out.Write(current)
+	}
+
+	for {
+		posting, ok, err := terms.next()
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		if current != nil && posting.Term == current.Term {
+			current.Docs = append(current.Docs, posting.Docs...)
+			continue
+		}
+		if err := write(); err != nil {
+			out.Close()
+			return err
+		}
+		current = &posting
+	}
+	if err := write(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func docBefore(a, b *models.Document) bool {
+	return a.ID < b.ID
+}
+
+func postingBefore(a, b storage.Posting) bool {
+	return a.Term < b.Term
+}
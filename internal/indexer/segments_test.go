@@ -0,0 +1,190 @@
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/storage"
+)
+
+// flushDocs adds the documents the way the workers do and flushes them,
+// like ProcessFiles once parsing is done
+func flushDocs(t *testing.T, idx *Indexer, docs ...*models.Document) {
+	t.Helper()
+
+	for _, doc := range docs {
+		idx.addDocument(doc)
+	}
+	if err := idx.flushSegment(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// savedDocs saves the index and returns its documents by title
+func savedDocs(t *testing.T, idx *Indexer) map[string]*models.Document {
+	t.Helper()
+
+	if err := idx.SaveToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	documents, err := storage.NewDiskStorage(idx.indexPath).LoadDocuments()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byTitle := make(map[string]*models.Document)
+	for _, doc := range documents {
+		byTitle[doc.Title] = doc
+	}
+	return byTitle
+}
+
+// cityTables are sql and pageview dumps for cityDumps
+func cityTables(t *testing.T) (sql, pageviews []string) {
+	t.Helper()
+
+	categories := "CREATE TABLE `categorylinks` (\n" +
+		"  `cl_from` int(8) unsigned NOT NULL DEFAULT 0,\n" +
+		"  `cl_to` varbinary(255) NOT NULL DEFAULT '',\n" +
+		"  `cl_type` enum('page','subcat','file') NOT NULL DEFAULT 'page',\n" +
+		"  PRIMARY KEY (`cl_from`,`cl_to`)\n" +
+		");\n" +
+		"INSERT INTO `categorylinks` VALUES (1,'Coastal_cities','page'),(2,'Coastal_cities','page'),(101,'Capitals','page'),(999,'Shared_pages','page'),(7,'Cities','subcat'),(555,'Missing','page');\n"
+
+	var rows []string
+	for f := 0; f < 3; f++ {
+		for i := 0; i < 8; i++ {
+			if (f+i)%3 == 0 {
+				rows = append(rows, fmt.Sprintf("(%d,0,'City_%d-%d')", f*100+i+1, f, (i+3)%8), fmt.Sprintf("(%d,0,'Capital_%d')", f*100+i+1, (f+2)%3))
+			}
+		}
+	}
+	links := pageLinksTable + "INSERT INTO `pagelinks` VALUES " + strings.Join(rows, ",") + ",(999,0,'Shared'),(555,0,'City_0-1');\n"
+
+	views := "en City_0-1 120 0\nen Capital_1 30 0\nen Shared 7 0\nen.m City_2-5 12 0\n"
+
+	sql = []string{writeDump(t, "enwiki-categorylinks.sql", categories), writeDump(t, "enwiki-pagelinks.sql", links)}
+	pageviews = []string{writeDump(t, "pageviews-20240101-000000.gz", views)}
+	return sql, pageviews
+}
+
+// buildWithTables runs a whole build with sql and pageview dumps and
+// returns the index and how many segments parsing flushed
+func buildWithTables(t *testing.T, budget int64, files, sql, pageviews []string) (string, int) {
+	t.Helper()
+
+	dir := t.TempDir()
+	idx := NewIndexer(dir, 2, Options{})
+	idx.SetMemoryBudget(budget)
+	if err := idx.ProcessFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	segments := idx.segments
+
+	if err := idx.ImportSQL(sql); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.ImportPageviews(pageviews); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.BuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.SaveToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, segments
+}
+
+// a build that flushes many segments and spills every stage writes the
+// same index as one that holds everything until parsing is done
+func TestSegmentedMatchesInMemory(t *testing.T) {
+	files := cityDumps(t)
+	sql, pageviews := cityTables(t)
+
+	want, segments := buildWithTables(t, 0, files, sql, pageviews)
+	if segments != 1 {
+		t.Fatalf("without a budget parsing flushed %d segments, want 1", segments)
+	}
+
+	saved := loadSaved(t, want)
+	docs := make(map[string]*models.Document)
+	for _, doc := range saved.Documents {
+		docs[doc.Title] = doc
+	}
+	if city := docs["City 0-0"]; city == nil || !strings.Contains(strings.Join(city.Categories, ","), "Coastal cities") ||
+		len(city.Aliases) != 1 {
+		t.Errorf("City 0-0 = %+v, want its sql category and its redirect", city)
+	}
+	if city := docs["City 0-1"]; city == nil || city.Popularity != 120 || len(city.Anchors) == 0 || city.PageRank == 0 {
+		t.Errorf("City 0-1 = %+v, want pageviews, anchors and pagerank", city)
+	}
+	clustered := 0
+	for _, doc := range saved.Documents {
+		if doc.Canonical != 0 {
+			clustered++
+		}
+	}
+	if clustered == 0 {
+		t.Error("no document was clustered with its copies")
+	}
+
+	for _, budget := range []int64{2000, 20000} {
+		dir, segments := buildWithTables(t, budget, files, sql, pageviews)
+		if segments < 2 {
+			t.Errorf("budget %d: parsing flushed %d segments, want several", budget, segments)
+		}
+
+		// gob writes maps in whatever order they range, so documents.gob is
+		// compared decoded and the rest byte for byte
+		compareSaved(t, loadSaved(t, dir), saved)
+		for _, name := range []string{"terms.gob", "ids.gob", "lengths.gob", "titles.gob", "metadata.json"} {
+			got, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := os.ReadFile(filepath.Join(want, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, other) {
+				t.Errorf("budget %d: %s differs from the build without a budget", budget, name)
+			}
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, "segments")); !os.IsNotExist(err) {
+			t.Errorf("budget %d: segments left behind, %v", budget, err)
+		}
+	}
+}
+
+// checkpoints hold only the documents since the last flush, a resumed
+// build still knows how many there are
+func TestCheckpointAfterFlush(t *testing.T) {
+	files := cityDumps(t)
+	dir := t.TempDir()
+
+	idx := NewIndexer(dir, 2, Options{})
+	idx.SetMemoryBudget(20000)
+	if err := idx.ProcessFiles(files[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := NewIndexer(dir, 2, Options{})
+	if err := resumed.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if len(resumed.documents) != 0 || resumed.docCount != 9 || resumed.segments != idx.segments {
+		t.Errorf("resumed with %d documents in memory, %d in all and %d segments, want 0, 9 and %d",
+			len(resumed.documents), resumed.docCount, resumed.segments, idx.segments)
+	}
+}
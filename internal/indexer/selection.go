@@ -0,0 +1,151 @@
+package indexer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// Selection picks a subset of the input for small dev indexes. pages are
+// checked by title before any text is parsed, zero values select everything
+type Selection struct {
+	MaxDocs    int            // stop once this many documents are indexed
+	SampleRate float64        // share of titles kept, by a hash of the title
+	SampleSeed uint64         // picks a different sample at the same rate
+	Include    *regexp.Regexp // titles have to match it
+	Exclude    *regexp.Regexp // titles must not match it
+	Titles     []string       // only these titles
+}
+
+// selector is the Selection of a build plus the running document count
+type selector struct {
+	Selection
+	titles map[string]bool
+	docs   atomic.Int64
+}
+
+func newSelector(sel Selection) *selector {
+	s := &selector{Selection: sel}
+	if len(sel.Titles) > 0 {
+		s.titles = make(map[string]bool, len(sel.Titles))
+		for _, title := range sel.Titles {
+			s.titles[models.TitleKey(title)] = true
+		}
+	}
+
+	return s
+}
+
+// selects tells whether a title passes the filters and the sample
+func (s *selector) selects(title string) bool {
+	if s.titles != nil && !s.titles[models.TitleKey(title)] {
+		return false
+	}
+	if s.Include != nil && !s.Include.MatchString(title) {
+		return false
+	}
+	if s.Exclude != nil && s.Exclude.MatchString(title) {
+		return false
+	}
+
+	return s.sampled(title)
+}
+
+// sampled keeps the same titles on every run, whatever order the files
+// are read in
+func (s *selector) sampled(title string) bool {
+	if s.SampleRate <= 0 || s.SampleRate >= 1 {
+		return true
+	}
+
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, s.SampleSeed)
+	h.Write([]byte(models.TitleKey(title)))
+
+	return float64(mix64(h.Sum64())) < s.SampleRate*math.MaxUint64
+}
+
+// mix64 is murmur3's finalizer. fnv on its own leaves the high bits of
+// similar titles too alike for an even sample
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
+
+// filters is true when selects can turn a title down
+func (s *selector) filters() bool {
+	return s.titles != nil || s.Include != nil || s.Exclude != nil || s.SampleRate > 0 && s.SampleRate < 1
+}
+
+// full is true once MaxDocs documents were admitted, readers stop early
+func (s *selector) full() bool {
+	return s.MaxDocs > 0 && s.docs.Load() >= int64(s.MaxDocs)
+}
+
+// admit decides on a page that's about to become a document: skipped as
+// sampled when the selection leaves it out, refused without a count when
+// MaxDocs is reached. full tells that refusal apart, the reader stops there
+// without recording the page so a resumed build still reads it. a reader
+// that still fails to make the document hands the slot back with release
+func (b *Build) admit(skip Skip) (ok, full bool) {
+	s := b.selector
+	if !s.selects(skip.Title) {
+		skip.Reason = SkipSampled
+		b.skip(skip)
+		return false, false
+	}
+
+	if s.MaxDocs <= 0 {
+		return true, false
+	}
+	if s.docs.Add(1) > int64(s.MaxDocs) {
+		s.docs.Add(-1)
+		return false, true
+	}
+
+	return true, false
+}
+
+func (b *Build) release() {
+	if b.selector.MaxDocs > 0 {
+		b.selector.docs.Add(-1)
+	}
+}
+
+// full is true once the build has all the documents it may take
+func (b *Build) full() bool {
+	return b.selector.full()
+}
+
+// ReadTitleList reads one title per line, skipping blank lines and #
+// comments. underscores are fine, "New_York" and "New York" are the same
+func ReadTitleList(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var titles []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			titles = append(titles, line)
+		}
+	}
+
+	return titles, scanner.Err()
+}
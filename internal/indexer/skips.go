@@ -0,0 +1,135 @@
+package indexer
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// reasons a page doesn't make it into the index
+const (
+	SkipRedirect  = "redirect"   // kept as an alias of its target instead
+	SkipNamespace = "namespace"  // not in -namespaces
+	SkipTooShort  = "too_short"  // hardly any text to begin with
+	SkipMalformed = "malformed"  // xml or json that didn't decode
+	SkipCleanText = "clean_text" // markup that cleaned down to nearly nothing, or broke the cleaner
+	SkipSampled   = "sampled"    // left out by the -sample, -include, -exclude or -titles options
+)
+
+// SkipReasons in the order summaries list them
+var SkipReasons = []string{SkipRedirect, SkipNamespace, SkipTooShort, SkipMalformed, SkipCleanText, SkipSampled}
+
+// Skip is a line of the quarantine file. File is the input stream and
+// Offset the byte offset of the page in it, decompressed
+type Skip struct {
+	Reason string `json:"reason"`
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+	Title  string `json:"title,omitempty"`
+	PageID int64  `json:"page_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// quarantined reasons are the ones worth looking at, redirects and other
+// namespaces are skipped on purpose
+func (s Skip) quarantined() bool {
+	return s.Reason == SkipTooShort || s.Reason == SkipMalformed || s.Reason == SkipCleanText
+}
+
+// skipLog counts skips per reason and writes the quarantined ones out
+type skipLog struct {
+	mutex   sync.Mutex
+	counts  map[string]int64
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// skip records why a page was left out
+func (b *Build) skip(s Skip) {
+	skips := b.skips
+	skips.mutex.Lock()
+	defer skips.mutex.Unlock()
+
+	skips.counts[s.Reason]++
+	if skips.encoder != nil && s.quarantined() {
+		skips.encoder.Encode(s)
+	}
+}
+
+func (b *Build) skipped() map[string]int64 {
+	b.skips.mutex.Lock()
+	defer b.skips.mutex.Unlock()
+
+	counts := make(map[string]int64, len(b.skips.counts))
+	for reason, n := range b.skips.counts {
+		counts[reason] = n
+	}
+
+	return counts
+}
+
+// quarantined is how much of the quarantine file was written so far
+func (b *Build) quarantined() int64 {
+	b.skips.mutex.Lock()
+	defer b.skips.mutex.Unlock()
+
+	if b.skips.file == nil {
+		return 0
+	}
+	info, err := b.skips.file.Stat()
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// OpenQuarantine starts writing skipped pages to path as jsonl. a resumed
+// build appends to what the interrupted one wrote up to its checkpoint,
+// the pages after it are parsed and skipped again
+func (idx *Indexer) OpenQuarantine(path string) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if !idx.resumed {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	if idx.resumed {
+		if info, err := file.Stat(); err == nil && info.Size() > idx.quarantined {
+			if err := file.Truncate(idx.quarantined); err != nil {
+				file.Close()
+				return err
+			}
+		}
+	}
+
+	skips := idx.build.skips
+	skips.mutex.Lock()
+	defer skips.mutex.Unlock()
+
+	skips.file = file
+	skips.encoder = json.NewEncoder(file)
+	return nil
+}
+
+func (idx *Indexer) CloseQuarantine() error {
+	skips := idx.build.skips
+	skips.mutex.Lock()
+	defer skips.mutex.Unlock()
+
+	if skips.file == nil {
+		return nil
+	}
+	err := skips.file.Close()
+	skips.file, skips.encoder = nil, nil
+
+	return err
+}
+
+// Skipped returns how many pages the build left out per reason
+func (idx *Indexer) Skipped() map[string]int64 {
+	return idx.build.skipped()
+}
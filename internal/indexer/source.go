@@ -0,0 +1,192 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// input formats, picked with -format or from the file extension
+const (
+	FormatXML   = "xml"   // mediawiki dumps, .xml and .bz2
+	FormatJSONL = "jsonl" // one json document per line
+	FormatText  = "text"  // one document per .txt or .md file
+	FormatHTML  = "html"  // one document per saved page
+	FormatZIM   = "zim"   // kiwix archives
+
+	// wikimedia enterprise html dumps, .ndjson or .tar.gz
+	FormatEnterprise = "enterprise"
+)
+
+var Formats = []string{FormatXML, FormatJSONL, FormatText, FormatHTML, FormatZIM, FormatEnterprise}
+
+var formatExtensions = map[string]string{
+	".xml":    FormatXML,
+	".bz2":    FormatXML,
+	".jsonl":  FormatJSONL,
+	".txt":    FormatText,
+	".md":     FormatText,
+	".html":   FormatHTML,
+	".htm":    FormatHTML,
+	".zim":    FormatZIM,
+	".ndjson": FormatEnterprise,
+}
+
+// SourceReader turns one input file into documents, sent to the docChan
+// it was created with
+type SourceReader interface {
+	ParseFile(filename string) error
+}
+
+// DetectFormat guesses the format from the extension, "" when unknown
+func DetectFormat(filename string) string {
+	if isTarball(filename) {
+		return FormatEnterprise
+	}
+
+	return formatExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+func NewSourceReader(format string, docChan chan<- *models.Document, build *Build) (SourceReader, error) {
+	switch format {
+	case FormatXML:
+		return NewParser(docChan, build), nil
+	case FormatJSONL:
+		return &JSONLReader{docChan: docChan, build: build}, nil
+	case FormatText:
+		return &TextReader{docChan: docChan, build: build}, nil
+	case FormatHTML:
+		return &HTMLReader{docChan: docChan, build: build}, nil
+	case FormatZIM:
+		return &ZIMReader{docChan: docChan, build: build}, nil
+	case FormatEnterprise:
+		return &EnterpriseReader{docChan: docChan, build: build}, nil
+	}
+
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// formatOf is the -format option, or what the extension says
+func (b *Build) formatOf(filename string) string {
+	if b.Options.Format != "" {
+		return b.Options.Format
+	}
+
+	return DetectFormat(filename)
+}
+
+// send hands a document read at offset in the stream key to the workers,
+// counted so checkpoints wait for it
+func (b *Build) send(docChan chan<- *models.Document, doc *models.Document, key string, offset int64) {
+	doc.Source, doc.SourceOffset = key, offset
+	b.progress.sent()
+	docChan <- doc
+}
+
+// errFull is what parseWhole's fn returns when -max-docs refused the file
+var errFull = errors.New("max docs reached")
+
+// refused is the error for a file admit turned down
+func refused(full bool) error {
+	if full {
+		return errFull
+	}
+
+	return nil
+}
+
+// parseWhole runs fn for a file that is a single document. the file counts
+// as done once fn returns, so a resumed build skips it, unless -max-docs
+// refused it
+func (b *Build) parseWhole(filename string, fn func() error) error {
+	if b.progress.offset(filename) == streamDone {
+		return nil
+	}
+
+	b.progress.begin()
+	err := fn()
+	if err != nil {
+		b.progress.end("", 0)
+		if errors.Is(err, errFull) {
+			return nil
+		}
+		return err
+	}
+	b.progress.end(filename, streamDone)
+
+	return nil
+}
+
+// textBuilder assembles document content paragraph by paragraph and keeps
+// track of the sections its headings start
+type textBuilder struct {
+	text     strings.Builder
+	sections []models.Section
+}
+
+func (tb *textBuilder) paragraph(text string) int {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return -1
+	}
+
+	if tb.text.Len() > 0 {
+		tb.text.WriteByte('\n')
+	}
+	offset := tb.text.Len()
+	tb.text.WriteString(text)
+
+	return offset
+}
+
+func (tb *textBuilder) heading(title string, level int) {
+	offset := tb.paragraph(title)
+	if offset < 0 {
+		return
+	}
+
+	if n := len(tb.sections); n > 0 {
+		tb.sections[n-1].End = offset - 1
+	} else if offset > 0 {
+		tb.sections = append(tb.sections, models.Section{Start: 0, End: offset - 1})
+	}
+	tb.sections = append(tb.sections, models.Section{
+		Heading: strings.Join(strings.Fields(title), " "),
+		Level:   level,
+		Start:   offset,
+	})
+}
+
+// finish returns the content and its sections, the lead included
+func (tb *textBuilder) finish() (string, []models.Section) {
+	content := tb.text.String()
+
+	if n := len(tb.sections); n > 0 {
+		tb.sections[n-1].End = len(content)
+	} else if content != "" {
+		tb.sections = []models.Section{{Start: 0, End: len(content)}}
+	}
+
+	return content, tb.sections
+}
+
+// fileURL points results at the file they came from
+func fileURL(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+
+	return "file://" + filepath.ToSlash(filename)
+}
+
+// titleFromFilename turns "getting-started_guide.md" into
+// "getting started guide"
+func titleFromFilename(filename string) string {
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	name = strings.NewReplacer("_", " ", "-", " ").Replace(name)
+
+	return strings.Join(strings.Fields(name), " ")
+}
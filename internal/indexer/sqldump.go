@@ -0,0 +1,170 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	sqlDumpRe     = regexp.MustCompile(`-(page|pagelinks|categorylinks|redirect|linktarget)\.sql(\.gz)?$`)
+	createTableRe = regexp.MustCompile("^CREATE TABLE `([^`]+)`")
+	columnRe      = regexp.MustCompile("^\\s*`([^`]+)`")
+)
+
+// SQLTable names the link table a wikimedia sql dump holds, e.g.
+// enwiki-latest-pagelinks.sql.gz, or "" when it isn't one
+func SQLTable(filename string) string {
+	m := sqlDumpRe.FindStringSubmatch(filename)
+	if m == nil {
+		return ""
+	}
+
+	return m[1]
+}
+
+// sqlRow is one tuple of an INSERT, looked up by column name
+type sqlRow struct {
+	columns map[string]int
+	values  []string
+}
+
+func (r sqlRow) get(column string) (string, bool) {
+	i, ok := r.columns[column]
+	if !ok || i >= len(r.values) {
+		return "", false
+	}
+
+	return r.values[i], true
+}
+
+func (r sqlRow) has(column string) bool {
+	_, ok := r.columns[column]
+	return ok
+}
+
+// readSQLDump streams the rows of a mysqldump file without a database.
+// column names come from its CREATE TABLE, so it copes with the schema
+// changing between dumps
+func readSQLDump(filename string, fn func(row sqlRow)) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(filename, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	buffered := bufio.NewReaderSize(reader, 1<<20)
+	columns := make(map[string]int)
+	inCreate := false
+
+	for {
+		line, err := buffered.ReadBytes('\n')
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		switch {
+		case createTableRe.Match(line):
+			inCreate = true
+			columns = make(map[string]int)
+
+		case inCreate && bytes.HasPrefix(line, []byte(")")):
+			inCreate = false
+
+		case inCreate:
+			if m := columnRe.FindSubmatch(line); m != nil {
+				columns[string(m[1])] = len(columns)
+			}
+
+		case bytes.HasPrefix(line, []byte("INSERT INTO ")):
+			values := bytes.Index(line, []byte(" VALUES "))
+			if values < 0 {
+				continue
+			}
+			if err := parseTuples(line[values+len(" VALUES "):], func(values []string) {
+				fn(sqlRow{columns: columns, values: values})
+			}); err != nil {
+				return fmt.Errorf("%s: %w", filename, err)
+			}
+		}
+	}
+}
+
+// parseTuples reads (1,'a',NULL),(2,'b\'c',3); calling fn for each tuple
+func parseTuples(s []byte, fn func(values []string)) error {
+	var values []string
+	var value strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			values = values[:0]
+
+		case '\'':
+			value.Reset()
+			for i++; i < len(s) && s[i] != '\''; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					value.WriteByte(unescapeSQL(s[i]))
+					continue
+				}
+				value.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return errors.New("unterminated string")
+			}
+			values = append(values, value.String())
+
+		case ')':
+			fn(values)
+
+		case ',', ';', '\n', '\r', ' ':
+
+		default:
+			end := i
+			for end < len(s) && s[end] != ',' && s[end] != ')' {
+				end++
+			}
+			values = append(values, string(s[i:end]))
+			i = end - 1 // let the separator through
+		}
+	}
+
+	return nil
+}
+
+func unescapeSQL(c byte) byte {
+	switch c {
+	case '0':
+		return 0
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'Z':
+		return 0x1a
+	}
+
+	return c
+}
@@ -0,0 +1,207 @@
+package indexer
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// writeDump writes an inline dump to a temporary file, gzipped when the
+// name says so
+func writeDump(t *testing.T, name, dump string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if strings.HasSuffix(name, ".gz") {
+		gz := gzip.NewWriter(file)
+		defer gz.Close()
+		_, err = gz.Write([]byte(dump))
+	} else {
+		_, err = file.Write([]byte(dump))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+const pageLinksTable = "CREATE TABLE `pagelinks` (\n" +
+	"  `pl_from` int(8) unsigned NOT NULL DEFAULT 0,\n" +
+	"  `pl_namespace` int(11) NOT NULL DEFAULT 0,\n" +
+	"  `pl_title` varbinary(255) NOT NULL DEFAULT '',\n" +
+	"  PRIMARY KEY (`pl_from`,`pl_namespace`,`pl_title`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=binary;\n"
+
+func TestReadSQLDump(t *testing.T) {
+	tests := []struct {
+		name string
+		dump string
+		want []map[string]string
+	}{
+		{
+			name: "multi-row insert",
+			dump: pageLinksTable +
+				"INSERT INTO `pagelinks` VALUES (1,0,'Paris'),(2,0,'Lyon'),(3,14,'Cities_in_France');\n",
+			want: []map[string]string{
+				{"pl_from": "1", "pl_namespace": "0", "pl_title": "Paris"},
+				{"pl_from": "2", "pl_namespace": "0", "pl_title": "Lyon"},
+				{"pl_from": "3", "pl_namespace": "14", "pl_title": "Cities_in_France"},
+			},
+		},
+		{
+			name: "escapes",
+			dump: pageLinksTable +
+				`INSERT INTO ` + "`pagelinks`" + ` VALUES (1,0,'O\'Brien'),(2,0,'Back\\slash'),(3,0,'Two\nlines'),(4,0,'Quote \"this\"');` + "\n",
+			want: []map[string]string{
+				{"pl_from": "1", "pl_namespace": "0", "pl_title": "O'Brien"},
+				{"pl_from": "2", "pl_namespace": "0", "pl_title": `Back\slash`},
+				{"pl_from": "3", "pl_namespace": "0", "pl_title": "Two\nlines"},
+				{"pl_from": "4", "pl_namespace": "0", "pl_title": `Quote "this"`},
+			},
+		},
+		{
+			name: "separators inside strings",
+			dump: pageLinksTable +
+				"INSERT INTO `pagelinks` VALUES (1,0,'Paris,_Texas'),(2,0,'Lyon_(disambiguation)'),(3,0,'a\\'),(b');\n",
+			want: []map[string]string{
+				{"pl_from": "1", "pl_namespace": "0", "pl_title": "Paris,_Texas"},
+				{"pl_from": "2", "pl_namespace": "0", "pl_title": "Lyon_(disambiguation)"},
+				{"pl_from": "3", "pl_namespace": "0", "pl_title": "a'),(b"},
+			},
+		},
+		{
+			name: "null and negative numbers",
+			dump: "CREATE TABLE `redirect` (\n" +
+				"  `rd_from` int(8) unsigned NOT NULL DEFAULT 0,\n" +
+				"  `rd_namespace` int(11) NOT NULL DEFAULT 0,\n" +
+				"  `rd_title` varbinary(255) NOT NULL DEFAULT '',\n" +
+				"  `rd_interwiki` varbinary(32) DEFAULT NULL,\n" +
+				"  `rd_fragment` varbinary(255) DEFAULT NULL,\n" +
+				"  PRIMARY KEY (`rd_from`)\n" +
+				");\n" +
+				"INSERT INTO `redirect` VALUES (10,0,'Paris',NULL,NULL),(11,-1,'Search','','History');\n",
+			want: []map[string]string{
+				{"rd_from": "10", "rd_namespace": "0", "rd_title": "Paris", "rd_interwiki": "NULL", "rd_fragment": "NULL"},
+				{"rd_from": "11", "rd_namespace": "-1", "rd_title": "Search", "rd_interwiki": "", "rd_fragment": "History"},
+			},
+		},
+		{
+			name: "columns of the newer schema",
+			dump: "CREATE TABLE `pagelinks` (\n" +
+				"  `pl_from` int(8) unsigned NOT NULL DEFAULT 0,\n" +
+				"  `pl_from_namespace` int(11) NOT NULL DEFAULT 0,\n" +
+				"  `pl_target_id` bigint(20) unsigned NOT NULL,\n" +
+				"  PRIMARY KEY (`pl_from`,`pl_target_id`)\n" +
+				");\n" +
+				"/*!40000 ALTER TABLE `pagelinks` DISABLE KEYS */;\n" +
+				"INSERT INTO `pagelinks` VALUES (1,0,100),(1,0,101);\n" +
+				"INSERT INTO `pagelinks` VALUES (2,0,100);\n",
+			want: []map[string]string{
+				{"pl_from": "1", "pl_from_namespace": "0", "pl_target_id": "100"},
+				{"pl_from": "1", "pl_from_namespace": "0", "pl_target_id": "101"},
+				{"pl_from": "2", "pl_from_namespace": "0", "pl_target_id": "100"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, name := range []string{"enwiki-pagelinks.sql", "enwiki-pagelinks.sql.gz"} {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				var rows []map[string]string
+				err := readSQLDump(writeDump(t, name, tt.dump), func(row sqlRow) {
+					values := make(map[string]string)
+					for column := range row.columns {
+						values[column], _ = row.get(column)
+					}
+					rows = append(rows, values)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(rows, tt.want) {
+					t.Errorf("rows = %q, want %q", rows, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestReadSQLDumpUnterminated(t *testing.T) {
+	path := writeDump(t, "enwiki-pagelinks.sql", pageLinksTable+"INSERT INTO `pagelinks` VALUES (1,0,'Paris);\n")
+	if err := readSQLDump(path, func(sqlRow) {}); err == nil {
+		t.Error("no error for an unterminated string")
+	}
+}
+
+// the pagelinks rows end up in the link graph the same whether they were
+// spilled to one run or many
+func TestImportPageLinks(t *testing.T) {
+	dump := pageLinksTable +
+		"INSERT INTO `pagelinks` VALUES (1,0,'Lyon'),(1,0,'Nice'),(1,0,'Paris'),(1,0,'Lyon'),(1,14,'Cities'),(1,0,'Missing'),(2,0,'Capital');\n" +
+		"INSERT INTO `pagelinks` VALUES (3,0,'Paris'),(3,0,'Lyon'),(99,0,'Paris');\n"
+	path := writeDump(t, "enwiki-pagelinks.sql", dump)
+
+	want := map[string][]string{
+		"Paris": {"Lyon", "Nice"}, // the self link is dropped and so is the duplicate
+		"Lyon":  {"Paris"},        // through the redirect, the text links are replaced
+		"Nice":  {"Lyon", "Paris"},
+		"Brest": {"Nice"}, // no rows, keeps the links of its text
+	}
+
+	titles := []string{"Paris", "Lyon", "Nice", "Brest"}
+	for _, budget := range []int64{0, 16} {
+		idx := NewIndexer(t.TempDir(), 1, Options{})
+		idx.SetMemoryBudget(budget)
+		var docs []*models.Document
+		for i, title := range titles {
+			doc := models.NewDocument(uint32(i+1), title, articleText("a city in france"), "", idx.build.Language())
+			doc.PageID = int64(i + 1)
+			doc.Links = []models.Link{{Target: "Nice"}}
+			docs = append(docs, doc)
+		}
+		flushDocs(t, idx, docs...)
+		idx.build.Redirects.Add("Capital", "Paris")
+
+		if err := idx.importPageLinks(path, nil); err != nil {
+			t.Fatal(err)
+		}
+		if budget > 0 && len(idx.linkRuns) < 2 {
+			t.Errorf("budget %d: links spilled to %d runs, want several", budget, len(idx.linkRuns))
+		}
+		if err := idx.resolveLinks(); err != nil {
+			t.Fatal(err)
+		}
+
+		graph := filepath.Join(idx.segmentDir(), "graph.gob")
+		if _, _, err := idx.writeLinkGraph(graph, uint32(len(titles))); err != nil {
+			t.Fatal(err)
+		}
+		links, err := readRun[linkRecord](graph)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make(map[string][]string)
+		for _, link := range links {
+			from := titles[link.From-1]
+			got[from] = append(got[from], titles[link.To-1])
+			slices.Sort(got[from])
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("budget %d: links = %q, want %q", budget, got, want)
+		}
+	}
+}
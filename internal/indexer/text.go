@@ -0,0 +1,119 @@
+package indexer
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+var (
+	mdHeadingRe  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	mdImageRe    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLinkRe     = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	mdEmphasisRe = regexp.MustCompile("(\\*\\*|__|\\*|`)")
+	mdListRe     = regexp.MustCompile(`^(\s*([-*+]|\d+[.)])\s+|>\s*)`)
+)
+
+// TextReader reads a .txt or .md file as one document. markdown headings
+// become sections and the first top level one the title
+type TextReader struct {
+	docChan chan<- *models.Document
+	build   *Build
+}
+
+func (r *TextReader) ParseFile(filename string) error {
+	return r.build.parseWhole(filename, func() error {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		title, content, sections := parseMarkdown(string(data))
+		if title == "" {
+			title = titleFromFilename(filename)
+		}
+		if len(content) < 50 {
+			r.build.skip(Skip{Reason: SkipTooShort, File: filename, Title: title})
+			return nil
+		}
+		if ok, full := r.build.admit(Skip{File: filename, Title: title}); !ok {
+			return refused(full)
+		}
+
+		doc := models.NewDocument(r.build.IDs.Next(), title, content, fileURL(filename), r.build.Language())
+		doc.Sections = sections
+		if info, err := os.Stat(filename); err == nil {
+			doc.Modified = info.ModTime().UTC()
+		}
+
+		r.build.send(r.docChan, doc, filename, 0)
+		return nil
+	})
+}
+
+// parseMarkdown strips the markup that plain text readers wouldn't want
+// indexed. a plain .txt file just comes out as paragraphs
+func parseMarkdown(src string) (string, string, []models.Section) {
+	var (
+		tb    textBuilder
+		para  []string
+		title string
+		fence bool
+	)
+
+	flush := func() {
+		tb.paragraph(strings.Join(para, " "))
+		para = para[:0]
+	}
+
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		// code blocks are kept, just not parsed
+		if strings.HasPrefix(trimmed, "```") {
+			flush()
+			fence = !fence
+			continue
+		}
+		if fence {
+			para = append(para, trimmed)
+			continue
+		}
+
+		switch {
+		case trimmed == "":
+			flush()
+
+		case mdHeadingRe.MatchString(trimmed):
+			flush()
+			m := mdHeadingRe.FindStringSubmatch(trimmed)
+			heading := markdownInline(m[2])
+			if title == "" && len(m[1]) == 1 {
+				title = heading
+				continue
+			}
+			tb.heading(heading, len(m[1]))
+
+		case mdListRe.MatchString(line):
+			flush()
+			para = append(para, markdownInline(mdListRe.ReplaceAllString(line, "")))
+			flush()
+
+		default:
+			para = append(para, markdownInline(trimmed))
+		}
+	}
+	flush()
+
+	content, sections := tb.finish()
+	return title, content, sections
+}
+
+func markdownInline(s string) string {
+	s = mdImageRe.ReplaceAllString(s, "$1")
+	s = mdLinkRe.ReplaceAllString(s, "$1")
+
+	return mdEmphasisRe.ReplaceAllString(s, "")
+}
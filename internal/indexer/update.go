@@ -0,0 +1,280 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/storage"
+)
+
+// baseIndex is the part of a saved index an update reads, the documents
+// and postings stay on disk
+type baseIndex struct {
+	metadata map[string]interface{}
+	pageIDs  map[int64]uint32
+	lengths  map[uint32]int
+	titles   map[string]uint32 // title key -> doc id, for redirects to unchanged articles
+	nextID   uint32            // ids up to here are taken
+}
+
+// OpenUpdate makes the indexer update the index saved at its path instead
+// of building a new one. only page ids, document lengths and titles are
+// loaded, ProcessFiles then parses the changed pages and SaveUpdate writes
+// them
+func (idx *Indexer) OpenUpdate() error {
+	metadata, err := readMetadata(idx.indexPath)
+	if err != nil {
+		return err
+	}
+	lang, _ := metadata["language"].(string)
+	urlBase, _ := metadata["url_base"].(string)
+	idx.build.detectLanguage(lang, urlBase)
+
+	pageIDs, idErr := idx.storage.LoadIDMap()
+	lengths, lenErr := idx.storage.LoadLengths()
+	titles, titleErr := idx.storage.LoadTitles()
+
+	// indexes from before updates, or before titles were saved, read the
+	// documents once to fill the gaps
+	missing := errors.Is(idErr, fs.ErrNotExist) || errors.Is(lenErr, fs.ErrNotExist) || errors.Is(titleErr, fs.ErrNotExist)
+	if missing {
+		fmt.Println("Reading documents for their page ids, lengths and titles, only needed once")
+		documents, err := idx.storage.LoadDocuments()
+		if err != nil {
+			return err
+		}
+		deltas, err := idx.storage.LoadDeltas()
+		if err != nil {
+			return err
+		}
+		storage.ApplyDeltas(documents, make(map[string][]uint32), deltas, idx.build.Language())
+
+		pageIDs = make(map[int64]uint32, len(documents))
+		lengths = make(map[uint32]int, len(documents))
+		titles = make(map[string]uint32, len(documents))
+		for id, doc := range documents {
+			if doc.PageID != 0 {
+				pageIDs[doc.PageID] = id
+			}
+			lengths[id] = doc.Length
+			titles[models.TitleKey(doc.Title)] = id
+		}
+	} else if err := errors.Join(idErr, lenErr, titleErr); err != nil {
+		return err
+	}
+
+	// new documents get ids the index never used
+	next := uint32(0)
+	if n, ok := metadata["next_id"].(float64); ok {
+		next = uint32(n)
+	}
+	for id := range lengths {
+		next = max(next, id)
+	}
+	idx.build.IDs.next.Store(next)
+
+	idx.base = &baseIndex{metadata: metadata, pageIDs: pageIDs, lengths: lengths, titles: titles, nextID: next}
+
+	// SaveUpdate needs the parsed documents, updates are small anyway
+	idx.budget = 0
+
+	fmt.Printf("Updating index with %d documents\n", len(lengths))
+	return nil
+}
+
+// SaveUpdate writes what ProcessFiles parsed as a delta on top of the saved
+// index. pages the index has are replaced under their old id, the rest are
+// added and pages the input deleted are removed. with prune the input is a
+// complete dump, so indexed pages it doesn't have are removed as well
+func (idx *Indexer) SaveUpdate(prune bool) error {
+	base := idx.base
+	if base == nil {
+		return errors.New("no index opened for update")
+	}
+
+	deleted := make(map[int64]bool)
+	for _, pageID := range idx.build.Deletions.Pages() {
+		deleted[pageID] = true
+	}
+	if prune {
+		for pageID := range base.pageIDs {
+			if _, ok := idx.pageIDs[pageID]; !ok {
+				deleted[pageID] = true
+			}
+		}
+	}
+
+	delta := &storage.Delta{Documents: make(map[uint32]*models.Document)}
+	for pageID := range deleted {
+		if id, ok := base.pageIDs[pageID]; ok {
+			delta.Deleted = append(delta.Deleted, id)
+			delete(base.pageIDs, pageID)
+			delete(base.lengths, id)
+		}
+	}
+	slices.Sort(delta.Deleted)
+
+	added, replaced := 0, 0
+	for id, doc := range idx.documents {
+		if doc.PageID != 0 {
+			// a page that's in the input twice keeps the copy read last
+			if deleted[doc.PageID] || idx.pageIDs[doc.PageID] != id {
+				continue
+			}
+
+			if old, ok := base.pageIDs[doc.PageID]; ok {
+				doc.ID = old
+				replaced++
+			} else {
+				base.pageIDs[doc.PageID] = doc.ID
+				added++
+			}
+		} else {
+			added++
+		}
+
+		doc.Source, doc.SourceOffset = "", 0
+		delta.Documents[doc.ID] = doc
+		base.lengths[doc.ID] = doc.Length
+	}
+
+	idx.resolveUpdateAliases(delta)
+
+	total := 0
+	for _, length := range base.lengths {
+		total += length
+	}
+	base.metadata["doc_count"] = len(base.lengths)
+	base.metadata["avg_doc_len"] = 0.0
+	if len(base.lengths) > 0 {
+		base.metadata["avg_doc_len"] = float64(total) / float64(len(base.lengths))
+	}
+	base.metadata["next_id"] = idx.build.IDs.next.Load()
+
+	fmt.Printf("Added %d, replaced %d and deleted %d documents\n", added, replaced, len(delta.Deleted))
+
+	if err := idx.storage.SaveIDMap(base.pageIDs); err != nil {
+		return err
+	}
+	if err := idx.storage.SaveLengths(base.lengths); err != nil {
+		return err
+	}
+	if err := idx.storage.SaveTitles(base.titles); err != nil {
+		return err
+	}
+	if err := writeMetadata(idx.indexPath, base.metadata); err != nil {
+		return err
+	}
+
+	// last, so an update that failed halfway leaves no delta behind
+	return idx.storage.SaveDelta(delta)
+}
+
+// resolveUpdateAliases attaches the redirects of an update to their
+// articles, whether the update has the article or only the saved index
+// does. redirects already on an article the update replaces come back
+// through Inherit when the delta is applied
+func (idx *Indexer) resolveUpdateAliases(delta *storage.Delta) {
+	base := idx.base
+
+	// titles of deleted and replaced documents may be gone or changed
+	for title, id := range base.titles {
+		if _, ok := base.lengths[id]; !ok || delta.Documents[id] != nil {
+			delete(base.titles, title)
+		}
+	}
+	for id, doc := range delta.Documents {
+		base.titles[models.TitleKey(doc.Title)] = id
+	}
+
+	redirects := idx.build.Redirects.Map()
+
+	aliases := 0
+	for from, to := range redirects {
+		id, ok := base.titles[models.TitleKey(resolveRedirect(redirects, to))]
+		if !ok {
+			continue
+		}
+
+		if doc := delta.Documents[id]; doc != nil {
+			doc.AddAlias(from)
+		} else {
+			if delta.Aliases == nil {
+				delta.Aliases = make(map[uint32][]string)
+			}
+			delta.Aliases[id] = append(delta.Aliases[id], from)
+		}
+		aliases++
+	}
+
+	fmt.Printf("Resolved %d of %d redirects\n", aliases, len(redirects))
+}
+
+// Compact folds the saved deltas into the base index, so the server doesn't
+// apply them every time it loads
+func (idx *Indexer) Compact() error {
+	metadata, err := readMetadata(idx.indexPath)
+	if err != nil {
+		return err
+	}
+	lang, _ := metadata["language"].(string)
+	urlBase, _ := metadata["url_base"].(string)
+	idx.build.detectLanguage(lang, urlBase)
+
+	deltas, err := idx.storage.LoadDeltas()
+	if err != nil {
+		return err
+	}
+	if len(deltas) == 0 {
+		fmt.Println("No updates to compact")
+		return nil
+	}
+
+	documents, err := idx.storage.LoadDocuments()
+	if err != nil {
+		return err
+	}
+	termIndex, err := idx.storage.LoadTermIndex()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Applying %d updates\n", len(deltas))
+	storage.ApplyDeltas(documents, termIndex, deltas, idx.build.Language())
+
+	idx.documents = documents
+	idx.termIndex = termIndex
+
+	if n, ok := metadata["next_id"].(float64); ok {
+		idx.build.IDs.next.Store(uint32(n))
+	}
+
+	return idx.SaveToDisk()
+}
+
+func readMetadata(indexPath string) (map[string]interface{}, error) {
+	metaData, err := os.ReadFile(filepath.Join(indexPath, "metadata.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata map[string]interface{}
+	err = json.Unmarshal(metaData, &metadata)
+
+	return metadata, err
+}
+
+func writeMetadata(indexPath string, metadata map[string]interface{}) error {
+	metaData, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(indexPath, "metadata.json"), metaData, 0644)
+}
@@ -0,0 +1,198 @@
+package indexer
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/search"
+)
+
+func redirectPage(title string, id int64, target string) string {
+	return fmt.Sprintf("<page><title>%s</title><ns>0</ns><id>%d</id><redirect title=%q /><revision><id>%d</id><text>#REDIRECT [[%s]]</text></revision></page>\n",
+		title, id, target, id*10, target)
+}
+
+func xmlDump(pages ...string) string {
+	return "<mediawiki>" + siteInfo + strings.Join(pages, "") + "</mediawiki>"
+}
+
+var (
+	paris     = page("Paris", 0, 1, articleText("paris capital france seine louvre"))
+	lyon      = page("Lyon", 0, 2, articleText("lyon rhone saone gastronomy silk"))
+	nice      = page("Nice", 0, 3, articleText("nice mediterranean coast carnival"))
+	niceNew   = page("Nice", 0, 3, articleText("nice riviera resort promenade beaches"))
+	brest     = page("Brest", 0, 4, articleText("brest brittany harbour navy atlantic"))
+	toulouse  = page("Toulouse", 0, 7, articleText("toulouse garonne aerospace brick"))
+	toulouse2 = page("Toulouse", 0, 7, articleText("toulouse garonne rugby cassoulet"))
+
+	cityOfLight = redirectPage("City of Light", 5, "Paris")
+	nizza       = redirectPage("Nizza", 6, "Nice")
+	lugdunum    = redirectPage("Lugdunum", 8, "Lyon")
+	pinkCity    = redirectPage("Pink City", 9, "Toulouse")
+)
+
+// buildTestIndex runs a whole build over the dumps, the way cmd/indexer
+// does without sql or pageview dumps
+func buildTestIndex(t *testing.T, budget int64, files ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	idx := NewIndexer(dir, 2, Options{})
+	idx.SetMemoryBudget(budget)
+	if err := idx.ProcessFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.BuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.SaveToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func updateTestIndex(t *testing.T, dir string, prune bool, files ...string) {
+	t.Helper()
+
+	idx := NewIndexer(dir, 2, Options{})
+	if err := idx.OpenUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.ProcessFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.SaveUpdate(prune); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// indexedPage is what a search sees of a page, ids aside
+type indexedPage struct {
+	Title   string
+	Aliases []string
+	Length  int
+}
+
+// snapshot loads the index like the server and lists its pages and what
+// a few searches return. link scores only get recomputed by a full build,
+// the text is what an update has to get right
+func snapshot(t *testing.T, dir string) ([]indexedPage, map[string][]string) {
+	t.Helper()
+
+	engine, err := search.NewEngine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.SetWeights(search.Weights{Anchor: 0.5, Disambiguation: 0.5})
+
+	var pages []indexedPage
+	for pageID := int64(1); pageID < 20; pageID++ {
+		if doc := engine.DocumentByPageID(pageID); doc != nil {
+			aliases := slices.Clone(doc.Aliases)
+			slices.Sort(aliases)
+			pages = append(pages, indexedPage{Title: doc.Title, Aliases: aliases, Length: doc.Length})
+		}
+	}
+
+	results := make(map[string][]string)
+	for _, query := range []string{"capital", "nice", "riviera", "carnival", "brest", "lugdunum", "nizza", "pink city", "city of light", "toulouse", "rugby aerospace", "garonne rhone"} {
+		resp, err := engine.SearchWithOptions(query, search.Options{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, result := range resp.Results {
+			results[query] = append(results[query], fmt.Sprintf("%s %.9f", result.Title, result.Score))
+		}
+	}
+
+	return pages, results
+}
+
+func compact(t *testing.T, dir string) {
+	t.Helper()
+
+	if err := NewIndexer(dir, 2, Options{}).Compact(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// an updated index searches like one rebuilt from the pages the update
+// leaves, before and after compacting it
+func TestUpdateMatchesRebuild(t *testing.T) {
+	base := xmlDump(paris, lyon, nice, brest, cityOfLight, nizza)
+
+	tests := []struct {
+		name    string
+		updates [][]string // the files of each update in turn
+		prune   bool
+		rebuild string
+	}{
+		{
+			name: "replace, add, delete and redirect",
+			updates: [][]string{{
+				xmlDump(niceNew, toulouse, lugdunum, pinkCity),
+				`{"id": 4, "deleted": true}` + "\n",
+			}},
+			rebuild: xmlDump(paris, lyon, niceNew, toulouse, cityOfLight, nizza, lugdunum, pinkCity),
+		},
+		{
+			name: "updates on top of updates",
+			updates: [][]string{
+				{xmlDump(niceNew, toulouse, lugdunum)},
+				{xmlDump(toulouse2, pinkCity), `{"id": 2, "deleted": true}` + "\n"},
+				{xmlDump(nice)},
+			},
+			rebuild: xmlDump(paris, nice, brest, toulouse2, cityOfLight, nizza, lugdunum, pinkCity),
+		},
+		{
+			name:    "prune",
+			updates: [][]string{{xmlDump(paris, niceNew, brest, toulouse)}},
+			prune:   true,
+			rebuild: xmlDump(paris, niceNew, brest, toulouse, cityOfLight, nizza),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantPages, wantResults := snapshot(t, buildTestIndex(t, 0, writeDump(t, "rebuild.xml", tt.rebuild)))
+
+			dir := buildTestIndex(t, 0, writeDump(t, "base.xml", base))
+			for _, update := range tt.updates {
+				var files []string
+				for i, dump := range update {
+					name := fmt.Sprintf("update-%d.xml", i)
+					if strings.HasPrefix(dump, "{") {
+						name = fmt.Sprintf("update-%d.jsonl", i)
+					}
+					files = append(files, writeDump(t, name, dump))
+				}
+				updateTestIndex(t, dir, tt.prune, files...)
+			}
+
+			for _, stage := range []string{"updated", "compacted"} {
+				if stage == "compacted" {
+					compact(t, dir)
+				}
+
+				pages, results := snapshot(t, dir)
+				if !reflect.DeepEqual(pages, wantPages) {
+					t.Errorf("%s pages = %+v, want %+v", stage, pages, wantPages)
+				}
+				for query, want := range wantResults {
+					if got := results[query]; !slices.Equal(got, want) {
+						t.Errorf("%s %q = %q, want %q", stage, query, got, want)
+					}
+				}
+				for query, got := range results {
+					if _, ok := wantResults[query]; !ok {
+						t.Errorf("%s %q = %q, want nothing", stage, query, got)
+					}
+				}
+			}
+		})
+	}
+}
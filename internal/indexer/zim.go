@@ -0,0 +1,504 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/net/html"
+)
+
+// https://wiki.openzim.org/wiki/ZIM_file_format
+const (
+	zimMagic = 72173914
+
+	zimRedirect   = 0xffff
+	zimLinkTarget = 0xfffe
+	zimDeleted    = 0xfffd
+
+	zimNone = 1
+	zimXZ   = 4
+	zimZstd = 5
+
+	// front articles in title order, as uint32 entry indexes
+	zimFrontListing = "listing/titleOrdered/v1"
+)
+
+// 639-3 codes from M/Language for the languages we can analyze
+var zimLanguages = map[string]string{
+	"eng": "en", "fra": "fr", "deu": "de", "spa": "es", "rus": "ru",
+	"swe": "sv", "nor": "no", "nob": "no", "hun": "hu",
+}
+
+type zimHeader struct {
+	Magic         uint32
+	Major, Minor  uint16
+	UUID          [16]byte
+	EntryCount    uint32
+	ClusterCount  uint32
+	URLPtrPos     uint64
+	TitlePtrPos   uint64
+	ClusterPtrPos uint64
+	MimeListPos   uint64
+	MainPage      uint32
+	LayoutPage    uint32
+	ChecksumPos   uint64
+}
+
+type zimEntry struct {
+	mime      uint16
+	namespace byte
+	cluster   uint32
+	blob      uint32
+	redirect  uint32 // entry index, redirects only
+	url       string
+	title     string
+}
+
+// ZIMReader reads the html articles of a kiwix .zim archive. clusters are
+// decompressed and parsed on the build's workers
+type ZIMReader struct {
+	docChan chan<- *models.Document
+	build   *Build
+}
+
+type zimArchive struct {
+	file     *os.File
+	header   zimHeader
+	mimes    []string
+	entries  []zimEntry
+	clusters []uint64
+	front    []bool // by entry index, nil without a front article listing
+}
+
+func (r *ZIMReader) ParseFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	z, err := openZIM(file)
+	if err != nil {
+		return fmt.Errorf("reading zim: %w", err)
+	}
+
+	urlBase := r.siteInfo(z)
+
+	// articles grouped by cluster so each is decompressed once
+	articles := make(map[uint32][]zimEntry)
+	for i, entry := range z.entries {
+		switch {
+		case entry.mime == zimRedirect && (entry.namespace == 'A' || entry.namespace == 'C'):
+			if target := int(entry.redirect); target < len(z.entries) && z.isArticle(target) {
+				r.build.Redirects.Add(entry.displayTitle(), z.entries[target].displayTitle())
+			}
+		case z.isArticle(i):
+			articles[entry.cluster] = append(articles[entry.cluster], entry)
+		}
+	}
+
+	order := make([]uint32, 0, len(articles))
+	for cluster := range articles {
+		order = append(order, cluster)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+
+	clusters := make(chan uint32)
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < r.build.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for cluster := range clusters {
+				key := fmt.Sprintf("%s@%d", filename, cluster)
+				if err := r.parseCluster(z, key, cluster, articles[cluster], urlBase); err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("cluster %d: %w", cluster, err)
+					}
+					errMutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, cluster := range order {
+		clusters <- cluster
+	}
+	close(clusters)
+	wg.Wait()
+
+	return firstErr
+}
+
+// siteInfo reads the language and source wiki from the M/ metadata and
+// returns the article url prefix
+func (r *ZIMReader) siteInfo(z *zimArchive) string {
+	var code, urlBase string
+
+	for _, entry := range z.entries {
+		if entry.namespace != 'M' || entry.mime >= zimDeleted {
+			continue
+		}
+
+		switch entry.url {
+		case "Language":
+			// may list several, "eng,fra"
+			if value, err := z.blob(entry); err == nil {
+				first, _, _ := strings.Cut(string(value), ",")
+				code = zimLanguages[strings.TrimSpace(first)]
+			}
+		case "Source":
+			if value, err := z.blob(entry); err == nil && strings.Contains(string(value), ".") {
+				urlBase = "https://" + strings.TrimSpace(string(value)) + "/wiki/"
+			}
+		}
+	}
+
+	r.build.detectLanguage(code, urlBase)
+	if r.build.Options.Language == "" && urlBase != "" {
+		return urlBase
+	}
+
+	return r.build.URLBase()
+}
+
+// parseCluster reads the entries of one cluster. the checkpoint offset is
+// the entry to pick up at when -max-docs stopped the build halfway through
+func (r *ZIMReader) parseCluster(z *zimArchive, key string, cluster uint32, entries []zimEntry, urlBase string) error {
+	progress := r.build.progress
+	resume := progress.offset(key)
+	if resume == streamDone || r.build.full() {
+		return nil
+	}
+
+	blobs, err := z.cluster(cluster)
+	if err != nil {
+		return err
+	}
+
+	progress.begin()
+	for i, entry := range entries {
+		if int64(i) < resume || int(entry.blob) >= len(blobs) {
+			continue
+		}
+		ok, full := r.build.admit(Skip{File: key, Title: entry.displayTitle()})
+		if full {
+			progress.end(key, int64(i))
+			return nil
+		}
+		if !ok {
+			continue
+		}
+		if doc := r.createDocument(entry, blobs[entry.blob], urlBase); doc != nil {
+			r.build.send(r.docChan, doc, key, int64(i))
+		} else {
+			r.build.release()
+			r.build.skip(Skip{Reason: SkipCleanText, File: key, Title: entry.displayTitle()})
+		}
+	}
+	progress.end(key, streamDone)
+
+	return nil
+}
+
+func (r *ZIMReader) createDocument(entry zimEntry, data []byte, urlBase string) *models.Document {
+	root, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+
+	title := entry.displayTitle()
+	page := parseHTML(root)
+	if len(page.content) < 50 {
+		return nil
+	}
+
+	doc := models.NewDocument(r.build.IDs.Next(), title, page.content, urlBase+entry.url, r.build.Language())
+	doc.Sections = page.sections
+	doc.Categories = page.categories
+	for _, link := range page.links {
+		if target := relativeLinkTitle(link.Href); target != "" {
+			doc.Links = append(doc.Links, models.Link{Target: target, Label: link.Label})
+		}
+	}
+	markDisambiguation(doc, page.disambiguation)
+
+	return doc
+}
+
+func (e zimEntry) displayTitle() string {
+	if e.title != "" {
+		return e.title
+	}
+
+	return strings.ReplaceAll(e.url, "_", " ")
+}
+
+// isArticle is an html page in the article namespace, A before the 6.1
+// format and C after. C also holds every other html page of the archive,
+// so there only the front articles count
+func (z *zimArchive) isArticle(i int) bool {
+	e := z.entries[i]
+	if e.mime >= zimDeleted || int(e.mime) >= len(z.mimes) {
+		return false
+	}
+	if e.namespace != 'A' && e.namespace != 'C' {
+		return false
+	}
+	if e.namespace == 'C' && z.front != nil && !z.front[i] {
+		return false
+	}
+
+	return strings.HasPrefix(z.mimes[e.mime], "text/html")
+}
+
+// readFrontArticles reads the X/listing/titleOrdered/v1 listing of 6.1
+// archives. older ones don't have it and keep going by mime type
+func (z *zimArchive) readFrontArticles() error {
+	for _, entry := range z.entries {
+		if entry.namespace != 'X' || entry.url != zimFrontListing || entry.mime >= zimDeleted {
+			continue
+		}
+
+		listing, err := z.blob(entry)
+		if err != nil {
+			return err
+		}
+
+		z.front = make([]bool, len(z.entries))
+		for j := 0; j+4 <= len(listing); j += 4 {
+			if i := binary.LittleEndian.Uint32(listing[j:]); int(i) < len(z.entries) {
+				z.front[i] = true
+			}
+		}
+		return nil
+	}
+
+	return nil
+}
+
+func openZIM(file *os.File) (*zimArchive, error) {
+	z := &zimArchive{file: file}
+
+	if err := binary.Read(io.NewSectionReader(file, 0, 80), binary.LittleEndian, &z.header); err != nil {
+		return nil, err
+	}
+	if z.header.Magic != zimMagic {
+		return nil, errors.New("not a zim file")
+	}
+
+	mimes, err := readStrings(file, int64(z.header.MimeListPos))
+	if err != nil {
+		return nil, fmt.Errorf("mime list: %w", err)
+	}
+	z.mimes = mimes
+
+	urlPtrs, err := readPointers(file, int64(z.header.URLPtrPos), int(z.header.EntryCount))
+	if err != nil {
+		return nil, fmt.Errorf("url pointers: %w", err)
+	}
+	z.clusters, err = readPointers(file, int64(z.header.ClusterPtrPos), int(z.header.ClusterCount))
+	if err != nil {
+		return nil, fmt.Errorf("cluster pointers: %w", err)
+	}
+
+	z.entries = make([]zimEntry, len(urlPtrs))
+	for i, ptr := range urlPtrs {
+		if z.entries[i], err = readEntry(file, int64(ptr)); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+
+	if err := z.readFrontArticles(); err != nil {
+		return nil, fmt.Errorf("front articles: %w", err)
+	}
+
+	return z, nil
+}
+
+func readPointers(file io.ReaderAt, pos int64, n int) ([]uint64, error) {
+	buf := make([]byte, 8*n)
+	if _, err := file.ReadAt(buf, pos); err != nil {
+		return nil, err
+	}
+
+	ptrs := make([]uint64, n)
+	for i := range ptrs {
+		ptrs[i] = binary.LittleEndian.Uint64(buf[8*i:])
+	}
+
+	return ptrs, nil
+}
+
+// readStrings reads zero terminated strings up to an empty one
+func readStrings(file io.ReaderAt, pos int64) ([]string, error) {
+	var list []string
+	for {
+		s, err := readString(file, pos)
+		if err != nil || s == "" {
+			return list, err
+		}
+		list = append(list, s)
+		pos += int64(len(s)) + 1
+	}
+}
+
+func readString(file io.ReaderAt, pos int64) (string, error) {
+	var b []byte
+	buf := make([]byte, 256)
+
+	for {
+		n, err := file.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], 0); i >= 0 {
+			return string(append(b, buf[:i]...)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		b = append(b, buf[:n]...)
+		pos += int64(n)
+	}
+}
+
+// readEntry decodes a directory entry:
+// mime(2) paramLen(1) namespace(1) revision(4), then cluster(4) blob(4) or
+// redirect index(4), then the url and title strings
+func readEntry(file io.ReaderAt, pos int64) (zimEntry, error) {
+	var head [16]byte
+	n, err := file.ReadAt(head[:], pos)
+	if n < 12 {
+		return zimEntry{}, err
+	}
+
+	e := zimEntry{
+		mime:      binary.LittleEndian.Uint16(head[0:]),
+		namespace: head[3],
+	}
+
+	pos += 8
+	switch e.mime {
+	case zimRedirect:
+		e.redirect = binary.LittleEndian.Uint32(head[8:])
+		pos += 4
+	case zimLinkTarget, zimDeleted:
+	default:
+		e.cluster = binary.LittleEndian.Uint32(head[8:])
+		e.blob = binary.LittleEndian.Uint32(head[12:])
+		pos += 8
+	}
+
+	if e.url, err = readString(file, pos); err != nil {
+		return e, err
+	}
+	pos += int64(len(e.url)) + 1
+	if e.title, err = readString(file, pos); err != nil {
+		return e, err
+	}
+
+	return e, nil
+}
+
+// cluster decompresses a cluster and splits it into its blobs
+func (z *zimArchive) cluster(i uint32) ([][]byte, error) {
+	if int(i) >= len(z.clusters) {
+		return nil, fmt.Errorf("no cluster %d", i)
+	}
+
+	start := int64(z.clusters[i])
+	end := int64(z.header.ChecksumPos)
+	if int(i)+1 < len(z.clusters) {
+		end = int64(z.clusters[i+1])
+	}
+	section := io.NewSectionReader(z.file, start+1, end-start-1)
+
+	var info [1]byte
+	if _, err := z.file.ReadAt(info[:], start); err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	switch info[0] & 0x0f {
+	case 0, zimNone:
+		reader = section
+	case zimXZ:
+		xzReader, err := xz.NewReader(section)
+		if err != nil {
+			return nil, err
+		}
+		reader = xzReader
+	case zimZstd:
+		zstdReader, err := zstd.NewReader(section)
+		if err != nil {
+			return nil, err
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	default:
+		return nil, fmt.Errorf("unsupported compression %d", info[0]&0x0f)
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+
+	// extended clusters use 8 byte offsets
+	size := 4
+	if info[0]&0x10 != 0 {
+		size = 8
+	}
+	offset := func(j int) int {
+		if size == 8 {
+			return int(binary.LittleEndian.Uint64(data[j*8:]))
+		}
+		return int(binary.LittleEndian.Uint32(data[j*4:]))
+	}
+
+	if len(data) < size {
+		return nil, errors.New("truncated cluster")
+	}
+	count := offset(0) / size
+	if count*size > len(data) {
+		return nil, errors.New("bad cluster offsets")
+	}
+
+	blobs := make([][]byte, 0, max(count-1, 0))
+	for j := 0; j+1 < count; j++ {
+		from, to := offset(j), offset(j+1)
+		if from > to || to > len(data) {
+			return nil, errors.New("bad blob offsets")
+		}
+		blobs = append(blobs, data[from:to])
+	}
+
+	return blobs, nil
+}
+
+// blob reads a single entry's content
+func (z *zimArchive) blob(e zimEntry) ([]byte, error) {
+	blobs, err := z.cluster(e.cluster)
+	if err != nil {
+		return nil, err
+	}
+	if int(e.blob) >= len(blobs) {
+		return nil, fmt.Errorf("no blob %d in cluster %d", e.blob, e.cluster)
+	}
+
+	return blobs[e.blob], nil
+}
@@ -1,21 +1,99 @@
 package models
 
 import (
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/Adit0507/wiki-search-engine/internal/utils"
 )
 
 type Document struct {
-	ID      uint32         `json:"id"`
-	Title   string         `json:"title"`
-	Content string         `json:"content"`
-	URL     string         `json:"url"`
-	Terms   map[string]int `json:"terms"`
-	Length  int            `json:"length"`
+	ID         uint32         `json:"id"`
+	PageID     int64          `json:"page_id"`     // wikipedia page id, stable across rebuilds
+	RevisionID int64          `json:"revision_id"` // revision the text was taken from
+	Title      string         `json:"title"`
+	Aliases    []string       `json:"aliases,omitempty"`
+	Content    string         `json:"content"`
+	Sections   []Section      `json:"sections,omitempty"`
+	Categories []string       `json:"categories,omitempty"`
+	Infobox    *Infobox       `json:"infobox,omitempty"`
+	Links      []Link         `json:"links,omitempty"`
+	PageRank   float64        `json:"pagerank"`   // 1 is an average article
+	Popularity int64          `json:"popularity"` // pageviews over the imported dumps
+	URL        string         `json:"url"`
+	Terms      map[string]int `json:"terms"`
+	Length     int            `json:"length"`
+
+	// who saved the revision and when
+	Modified    time.Time `json:"modified,omitzero"`
+	Contributor string    `json:"contributor,omitempty"` // username, or ip for anonymous edits
+	Comment     string    `json:"comment,omitempty"`     // edit summary
+
+	// text of the links pointing here, scored apart from the body
+	Anchors      []Anchor       `json:"anchors,omitempty"`
+	AnchorTerms  map[string]int `json:"anchor_terms,omitempty"`
+	AnchorLength int            `json:"anchor_length"`
+
+	// disambiguation pages and the article titles they list
+	Disambiguation bool     `json:"disambiguation,omitempty"`
+	Candidates     []string `json:"candidates,omitempty"`
+
+	// near-duplicate detection, Canonical is the doc id standing for the
+	// cluster the document is in, its own id for the canonical one and 0
+	// when it has no near-duplicates
+	SimHash   uint64 `json:"simhash"`
+	Canonical uint32 `json:"canonical,omitempty"`
+
+	// the input stream the page was read from and where in it, which the
+	// build numbers documents by. cleared before the index is saved
+	Source       string `json:"-"`
+	SourceOffset int64  `json:"-"`
+
+	lang *utils.Language // analyzer the terms came from, not persisted
 }
 
-func NewDocument(id uint32, title, content, url string) *Document {
+// Section is a span of Content under one heading. the lead section before
+// the first heading has an empty Heading and level 0
+type Section struct {
+	Heading string `json:"heading"`
+	Level   int    `json:"level"`
+	Start   int    `json:"start"` // byte offsets into Content
+	End     int    `json:"end"`
+}
+
+// Text returns the part of Content the section covers
+func (s Section) Text(content string) string {
+	if s.Start < 0 || s.End > len(content) || s.Start > s.End {
+		return ""
+	}
+
+	return content[s.Start:s.End]
+}
+
+// Infobox holds the key/value pairs of an article's infobox. keys are
+// lowercase with underscores, Numbers and Dates hold the values that could
+// be normalized, dates as yyyy-mm-dd
+type Infobox struct {
+	Name    string             `json:"name"`
+	Fields  map[string]string  `json:"fields"`
+	Numbers map[string]float64 `json:"numbers,omitempty"`
+	Dates   map[string]string  `json:"dates,omitempty"`
+}
+
+// Link is an outgoing link to another article, as written in the text
+type Link struct {
+	Target string `json:"target"`
+	Label  string `json:"label"`
+}
+
+// Anchor is a label other articles link here with, and how often
+type Anchor struct {
+	Text  string `json:"text"`
+	Count int    `json:"count"`
+}
+
+func NewDocument(id uint32, title, content, url string, lang *utils.Language) *Document {
 	doc := &Document{
 		ID:      id,
 		Title:   title,
@@ -23,26 +101,100 @@ func NewDocument(id uint32, title, content, url string) *Document {
 		URL:     url,
 		Terms:   make(map[string]int),
 		Length:  0,
+		lang:    lang,
 	}
 
 	doc.processText()
+	doc.computeSimHash()
 
 	return doc
 }
 
 func (d *Document) processText() {
 	// combin title & content for indexig
-	text := strings.ToLower(d.Title + " " + d.Title + " " + d.Content)
+	d.addTerms(d.Title + " " + d.Title + " " + d.Content)
+}
 
-	tokens := utils.Tokenize(text)
-	for _, token := range tokens {
-		stemmed := utils.Stem(token)
+// AddAlias indexes another title for the document, weighted like the title.
+// returns the terms the document didn't have before
+func (d *Document) AddAlias(alias string) []string {
+	d.Aliases = append(d.Aliases, alias)
 
-		if len(stemmed) > 2 { //filtering out very short terms
-			d.Terms[stemmed]++
-			d.Length++
+	return d.addTerms(alias + " " + alias)
+}
+
+func (d *Document) addTerms(text string) []string {
+	var added []string
+
+	for _, stemmed := range d.analyze(text) {
+		if d.Terms[stemmed] == 0 {
+			added = append(added, stemmed)
 		}
+		d.Terms[stemmed]++
+		d.Length++
 	}
+
+	return added
+}
+
+// SetAnchors replaces the incoming link text, every label counts as often
+// as it was used
+func (d *Document) SetAnchors(anchors []Anchor) {
+	d.Anchors = anchors
+	d.AnchorTerms = make(map[string]int)
+	d.AnchorLength = 0
+
+	for _, anchor := range anchors {
+		for _, stemmed := range d.analyze(anchor.Text) {
+			d.AnchorTerms[stemmed] += anchor.Count
+			d.AnchorLength += anchor.Count
+		}
+	}
+}
+
+// SetLanguage sets the analyzer for terms added from now on, documents read
+// back from disk forget theirs
+func (d *Document) SetLanguage(lang *utils.Language) {
+	d.lang = lang
+}
+
+// Inherit carries over from an older revision of the same page what the
+// page can't tell by itself: link analysis, pageviews, incoming anchors and
+// the redirects pointing at it
+func (d *Document) Inherit(old *Document, lang *utils.Language) {
+	d.lang = lang
+	d.PageRank = old.PageRank
+	d.Popularity = old.Popularity
+	d.Anchors = old.Anchors
+	d.AnchorTerms = old.AnchorTerms
+	d.AnchorLength = old.AnchorLength
+
+	// still the same text give or take, so still in the same cluster
+	if NearDuplicate(d.SimHash, old.SimHash) {
+		d.Canonical = old.Canonical
+	}
+
+	for _, alias := range old.Aliases {
+		if !slices.Contains(d.Aliases, alias) {
+			d.AddAlias(alias)
+		}
+	}
+}
+
+func (d *Document) analyze(text string) []string {
+	lang := d.lang
+	if lang == nil {
+		lang = utils.English
+	}
+
+	var stemmed []string
+	for _, token := range lang.Tokenize(strings.ToLower(text)) {
+		if stem := lang.Stem(token); len(stem) > 2 { //filtering out very short terms
+			stemmed = append(stemmed, stem)
+		}
+	}
+
+	return stemmed
 }
 
 func (d *Document) GetTermFreq(term string) int {
@@ -52,3 +204,14 @@ func (d *Document) GetTermFreq(term string) int {
 func (d *Document) GetLength() int {
 	return d.Length
 }
+
+
+// TitleKey normalizes a title for exact lookups
+func TitleKey(title string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(title, "_", " ")), " "))
+}
+
+// FieldKey normalizes an infobox parameter name, "Birth date" -> "birth_date"
+func FieldKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(name, "_", " ")), "_"))
+}
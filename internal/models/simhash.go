@@ -0,0 +1,71 @@
+package models
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+
+	"github.com/Adit0507/wiki-search-engine/internal/utils"
+)
+
+const (
+	shingleSize = 3 // words per feature
+
+	// fewer words than this make for hashes that collide by chance
+	minSimHashWords = 10
+
+	// bits two hashes may differ in and still count as near-duplicates,
+	// 3 of 64 is what the simhash paper settled on for web pages
+	SimHashDistance = 3
+)
+
+// computeSimHash fingerprints the content so that near-identical texts get
+// hashes a few bits apart. 0 means too short to tell
+func (d *Document) computeSimHash() {
+	lang := d.lang
+	if lang == nil {
+		lang = utils.English
+	}
+
+	words := lang.Tokenize(strings.ToLower(d.Content))
+	if len(words) < minSimHashWords {
+		d.SimHash = 0
+		return
+	}
+
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h := fnv.New64a()
+		for _, word := range words[i : i+shingleSize] {
+			h.Write([]byte(word))
+			h.Write([]byte{' '})
+		}
+
+		sum := h.Sum64()
+		for bit := range weights {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var hash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << bit
+		}
+	}
+	d.SimHash = hash
+}
+
+// NearDuplicate tells whether two simhashes are close enough to count the
+// texts as the same
+func NearDuplicate(a, b uint64) bool {
+	if a == 0 || b == 0 {
+		return false
+	}
+
+	return bits.OnesCount64(a^b) <= SimHashDistance
+}
@@ -3,7 +3,6 @@ package search
 import (
 	"math"
 	"sort"
-	"strings"
 
 	"github.com/Adit0507/wiki-search-engine/internal/models"
 	"github.com/Adit0507/wiki-search-engine/internal/utils"
@@ -17,67 +16,197 @@ const (
 type BM25 struct {
 	documents map[uint32]*models.Document
 	termIndex map[string][]uint32
+	titles    map[string]uint32
+	keywords  *keywordIndex
 	docCount  int
 	avgDocLen float64
+	lang      *utils.Language
+	weights   Weights
+
+	disambiguations map[string]uint32 // see buildDisambiguationIndex
 }
 
-func NewBM25(documents map[uint32]*models.Document, termIndex map[string][]uint32, docCount int, avgDocLen float64) *BM25 {
+func NewBM25(documents map[uint32]*models.Document, termIndex map[string][]uint32, docCount int, avgDocLen float64, lang *utils.Language) *BM25 {
 	return &BM25{
 		documents: documents,
 		termIndex: termIndex,
+		titles:    buildTitleIndex(documents),
+		keywords:  buildKeywordIndex(documents, lang),
 		docCount:  docCount,
 		avgDocLen: avgDocLen,
+		lang:      lang,
+		weights:   DefaultWeights(),
+
+		disambiguations: buildDisambiguationIndex(documents),
 	}
 }
 
-func (bm *BM25) Search(query string, limit int) ([]Result, error) {
-	// tokenize and stem query
-	terms := utils.Tokenize(strings.ToLower(query))
-	stemmedTerms := make([]string, 0, len(terms))
+// buildTitleIndex maps titles and redirect aliases to their document,
+// real titles win over aliases
+func buildTitleIndex(documents map[uint32]*models.Document) map[string]uint32 {
+	titles := make(map[string]uint32, len(documents))
+	for id, doc := range documents {
+		titles[models.TitleKey(doc.Title)] = id
+	}
 
-	for _, term := range terms {
-		stemmed := utils.Stem(term)
-		if len(stemmed) > 2 {
-			stemmedTerms = append(stemmedTerms, stemmed)
+	for id, doc := range documents {
+		for _, alias := range doc.Aliases {
+			key := models.TitleKey(alias)
+			if _, exists := titles[key]; !exists {
+				titles[key] = id
+			}
 		}
 	}
 
-	if len(stemmedTerms) == 0 {
-		return []Result{}, nil
+	return titles
+}
+
+func (bm *BM25) Search(query string, limit int) ([]Result, error) {
+	resp, err := bm.SearchWithOptions(query, Options{Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Results, nil
+}
+
+func (bm *BM25) SearchWithOptions(query string, opts Options) (*Response, error) {
+	// infobox.key:value clauses filter, the rest is scored
+	pq := bm.parseQuery(query)
+	query = pq.text
+
+	// tokenize and stem query
+	stemmedTerms := analyze(bm.lang, query)
+
+	exactID, exact := bm.titles[models.TitleKey(query)]
+
+	if len(stemmedTerms) == 0 && !exact && !pq.hasClauses() {
+		return &Response{Results: []Result{}}, nil
 	}
 
+	filter := bm.newFilter(opts, pq)
+
 	candidates := bm.getCandidateDocuments(stemmedTerms)
+	if exact {
+		candidates[exactID] = true
+	}
+
+	// nothing to score, so whatever the clauses allow is the result,
+	// ranked on the field terms themselves
+	fieldOnly := len(stemmedTerms) == 0 && !exact
+	if fieldOnly {
+		for docID := range filter.allowed {
+			candidates[docID] = true
+		}
+		for _, clause := range pq.fields {
+			stemmedTerms = append(stemmedTerms, clause.terms...)
+		}
+	}
 
 	// scorin documents
 	results := make([]Result, 0, len(candidates))
 	for docID := range candidates {
 		doc := bm.documents[docID]
-		if doc == nil {
+		if doc == nil || !filter.matches(doc) {
 			continue
 		}
 
-		score := bm.calculateBM25Score(stemmedTerms, doc)
-		if score > 0 {
-			snippet := bm.generateSnippet(doc, stemmedTerms, 200)
-			results = append(results, Result{
-				DocID:   docID,
-				Title:   doc.Title,
-				URL:     doc.URL,
-				Score:   score,
-				Snippet: snippet,
-			})
+		score := bm.calculateBM25Score(stemmedTerms, doc) + bm.weights.Anchor*bm.anchorScore(stemmedTerms, doc)
+		if score > 0 || docID == exactID && exact || fieldOnly {
+			score += bm.staticScore(doc)
+			if doc.Disambiguation {
+				score *= 1 - bm.weights.Disambiguation
+			}
+			results = append(results, bm.result(doc, score, stemmedTerms))
 		}
 	}
 
 	// sortin by score
 	sort.Sort(ResultSet(results))
 
+	if opts.Sort == SortModified {
+		sortByModified(results)
+	} else if exact && !bm.documents[exactID].Disambiguation {
+		results = promoteExact(results, bm.documents[exactID], query)
+	}
+
+	if !opts.KeepDuplicates {
+		pinned := uint32(0)
+		if exact && opts.Sort != SortModified {
+			pinned = exactID
+		}
+		results = bm.collapseDuplicates(results, stemmedTerms, filter, pinned)
+	}
+
+	resp := &Response{
+		Total:  len(results),
+		Facets: bm.categoryFacets(results, facetCount),
+	}
+
+	// the query is ambiguous, list what it could mean
+	if dab := bm.disambiguationFor(query); dab != nil {
+		resp.DidYouMean = bm.candidates(dab)
+	}
+
 	// limit results
-	if limit < len(results) {
+	if limit := opts.limit(); limit < len(results) {
 		results = results[:limit]
 	}
 
-	return results, nil
+	// only worth finding sections for what's actually returned
+	for i := range results {
+		if section := bm.bestSection(bm.documents[results[i].DocID], stemmedTerms); section != nil {
+			results[i].Section = section.Heading
+			results[i].SectionURL = sectionURL(results[i].URL, section.Heading)
+		}
+	}
+	resp.Results = results
+
+	return resp, nil
+}
+
+func (bm *BM25) result(doc *models.Document, score float64, terms []string) Result {
+	return Result{
+		DocID:      doc.ID,
+		PageID:     doc.PageID,
+		RevisionID: doc.RevisionID,
+		Title:      doc.Title,
+		URL:        doc.URL,
+		Score:      score,
+		Snippet:    bm.generateSnippet(doc, terms, 200),
+		Modified:   doc.Modified,
+
+		Disambiguation: doc.Disambiguation,
+	}
+}
+
+// promoteExact moves the article whose title or alias is exactly the query
+// to the top of the results
+func promoteExact(results []Result, doc *models.Document, query string) []Result {
+	key := models.TitleKey(query)
+
+	for i, result := range results {
+		if result.DocID != doc.ID {
+			continue
+		}
+
+		if models.TitleKey(doc.Title) != key {
+			for _, alias := range doc.Aliases {
+				if models.TitleKey(alias) == key {
+					result.Alias = alias
+				}
+			}
+		}
+		if len(results) > 0 && result.Score < results[0].Score {
+			result.Score = results[0].Score
+		}
+
+		copy(results[1:i+1], results[:i])
+		results[0] = result
+		break
+	}
+
+	return results
 }
 
 func (bm *BM25) generateSnippet(doc *models.Document, terms []string, maxLen int) string {
@@ -166,6 +295,13 @@ func (bm *BM25) getCandidateDocuments(terms []string) map[uint32]bool {
 				candidates[docId] = true
 			}
 		}
+
+		// pages only linked to with the term count too
+		if bm.weights.Anchor > 0 {
+			for _, docId := range bm.keywords.anchors[term] {
+				candidates[docId] = true
+			}
+		}
 	}
 
 	return candidates
@@ -201,3 +337,27 @@ func (bm *BM25) calculateBM25Score(terms []string, doc *models.Document) float64
 
 	return score
 }
+
+// anchorScore is bm25 over the incoming link text, with its own document
+// frequencies and average length
+func (bm *BM25) anchorScore(terms []string, doc *models.Document) float64 {
+	if doc.AnchorLength == 0 || bm.keywords.anchorLen == 0 {
+		return 0
+	}
+
+	score := 0.0
+	for _, term := range terms {
+		tf := float64(doc.AnchorTerms[term])
+		df := float64(len(bm.keywords.anchors[term]))
+		if tf == 0 || df == 0 {
+			continue
+		}
+
+		idf := math.Log((float64(bm.docCount) - df + 0.5) / (df + 0.5))
+		normalization := K1 * ((1 - B) + B*(float64(doc.AnchorLength)/bm.keywords.anchorLen))
+
+		score += idf * (tf * (K1 + 1)) / (tf + normalization)
+	}
+
+	return score
+}
@@ -0,0 +1,264 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// withFiller adds articles about something else, bm25 only rewards terms
+// fewer than half the documents have
+func withFiller(docs ...*models.Document) *BM25 {
+	for i := 0; i < 8; i++ {
+		docs = append(docs, testDoc(uint32(100+i), fmt.Sprintf("Filler %d", i), fmt.Sprintf("unrelated filler text number %d about weather gardening and cooking", i)))
+	}
+
+	return testIndex(docs...)
+}
+
+func scores(t *testing.T, bm *BM25, query string, opts Options) map[string]float64 {
+	t.Helper()
+
+	resp, err := bm.SearchWithOptions(query, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := make(map[string]float64)
+	for _, result := range resp.Results {
+		found[result.Title] = result.Score
+	}
+
+	return found
+}
+
+func TestPageRankBoost(t *testing.T) {
+	planet := testDoc(1, "Mercury planet", "mercury is the smallest planet and the closest one to the sun")
+	element := testDoc(2, "Mercury element", "mercury is a chemical element that is liquid at room temperature")
+	bm := withFiller(planet, element)
+
+	bm.SetWeights(Weights{})
+	text := scores(t, bm, "mercury", Options{})
+
+	planet.PageRank, element.PageRank = 0.5, 20
+	bm.SetWeights(Weights{PageRank: 1})
+	ranked := scores(t, bm, "mercury", Options{})
+
+	for _, doc := range []*models.Document{planet, element} {
+		if got, want := ranked[doc.Title], text[doc.Title]+math.Log1p(doc.PageRank); math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s scored %v, want %v", doc.Title, got, want)
+		}
+	}
+	if got := titles(t, bm, "mercury", Options{}); len(got) == 0 || got[0] != "Mercury element" {
+		t.Errorf("results %q, want the better linked Mercury element first", got)
+	}
+
+	// no pagerank means no boost, not a penalty
+	planet.PageRank = 0
+	if got := scores(t, bm, "mercury", Options{})["Mercury planet"]; got != text["Mercury planet"] {
+		t.Errorf("Mercury planet without pagerank scored %v, want %v", got, text["Mercury planet"])
+	}
+}
+
+func TestAnchorText(t *testing.T) {
+	paris := testDoc(1, "Paris", "paris is the largest city of france on the river seine")
+	lyon := testDoc(2, "Lyon", "lyon is a large city of france where two rivers meet")
+	paris.SetAnchors([]models.Anchor{{Text: "Lutetia", Count: 3}, {Text: "the capital", Count: 2}})
+	lyon.SetAnchors([]models.Anchor{{Text: "Lyon", Count: 4}})
+	bm := withFiller(paris, lyon)
+
+	// only the links call it that
+	if got := titles(t, bm, "lutetia", Options{}); len(got) != 1 || got[0] != "Paris" {
+		t.Errorf("lutetia found %q, want Paris from its anchors", got)
+	}
+
+	// both texts say city, the links make Paris the better match for it
+	bm.SetWeights(Weights{})
+	text := scores(t, bm, "city capital", Options{})
+	if got := titles(t, bm, "lutetia", Options{}); len(got) > 0 {
+		t.Errorf("lutetia found %q without anchor text", got)
+	}
+
+	bm.SetWeights(Weights{Anchor: 0.5})
+	anchored := scores(t, bm, "city capital", Options{})
+	if anchored["Paris"] <= text["Paris"] {
+		t.Errorf("Paris scored %v with anchors, %v without", anchored["Paris"], text["Paris"])
+	}
+	if anchored["Lyon"] != text["Lyon"] {
+		t.Errorf("Lyon scored %v with anchors that don't match, %v without", anchored["Lyon"], text["Lyon"])
+	}
+}
+
+func TestPopularityBoost(t *testing.T) {
+	planet := testDoc(1, "Mercury planet", "mercury is the smallest planet and the closest one to the sun")
+	element := testDoc(2, "Mercury element", "mercury is a chemical element that is liquid at room temperature")
+	bm := withFiller(planet, element)
+
+	bm.SetWeights(Weights{})
+	text := scores(t, bm, "mercury", Options{})
+	first := titles(t, bm, "mercury", Options{})[0]
+
+	// whichever one the text prefers, enough views make the other win
+	popular, other := planet, element
+	if first == planet.Title {
+		popular, other = element, planet
+	}
+	popular.Popularity, other.Popularity = 5_000_000, 10
+
+	bm.SetWeights(Weights{Popularity: 0.25})
+	viewed := scores(t, bm, "mercury", Options{})
+	for _, doc := range []*models.Document{planet, element} {
+		if got, want := viewed[doc.Title], text[doc.Title]+0.25*math.Log1p(float64(doc.Popularity)); math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s scored %v, want %v", doc.Title, got, want)
+		}
+	}
+	if got := titles(t, bm, "mercury", Options{}); got[0] != popular.Title {
+		t.Errorf("results %q, want the popular %s first", got, popular.Title)
+	}
+}
+
+func TestCollapseDuplicates(t *testing.T) {
+	stub := "is a village in southern poland with an old wooden church"
+	village := testDoc(1, "Zabierzów", "zabierzów "+stub)
+	bochenski := testDoc(2, "Zabierzów Bocheński", "zabierzów bocheński "+stub+" wooden church")
+	copied := testDoc(3, "Zabierzów copy", "zabierzów "+stub+" copy")
+	krakow := testDoc(4, "Kraków", "kraków is a city in southern poland with many a wooden church")
+	for _, doc := range []*models.Document{village, bochenski, copied} {
+		doc.Canonical = village.ID
+	}
+	village.Categories = []string{"Villages"}
+	bochenski.Categories = []string{"Villages", "Bochnia County"}
+	copied.Categories = []string{"Villages", "Bochnia County"}
+	bm := withFiller(village, bochenski, copied, krakow)
+	bm.SetWeights(Weights{})
+
+	all, err := bm.SearchWithOptions("wooden church", Options{KeepDuplicates: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Results) != 4 || all.Results[0].Title != "Zabierzów Bocheński" {
+		t.Fatalf("kept duplicates %+v, want all four with Zabierzów Bocheński first", all.Results)
+	}
+	best := all.Results[0].Score
+
+	tests := []struct {
+		query      string
+		opts       Options
+		want       string
+		duplicates int
+	}{
+		// the canonical stands for the cluster where its best member ranked
+		{query: "wooden church", want: "Zabierzów", duplicates: 2},
+		// a title search keeps the page it names
+		{query: "zabierzów bocheński", want: "Zabierzów Bocheński", duplicates: 2},
+		// the canonical isn't in the category, so the best member that is shows
+		{query: "wooden church", opts: Options{Categories: []string{"Bochnia County"}}, want: "Zabierzów Bocheński", duplicates: 1},
+	}
+
+	for _, tt := range tests {
+		resp, err := bm.SearchWithOptions(tt.query, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var clusters []Result
+		for _, result := range resp.Results {
+			if result.Title != "Kraków" {
+				clusters = append(clusters, result)
+			}
+		}
+		if len(clusters) != 1 {
+			t.Errorf("%s %+v: %d results from the cluster, want 1", tt.query, tt.opts, len(clusters))
+			continue
+		}
+		if got := clusters[0]; got.Title != tt.want || got.Duplicates != tt.duplicates {
+			t.Errorf("%s %+v: %s with %d duplicates, want %s with %d", tt.query, tt.opts, got.Title, got.Duplicates, tt.want, tt.duplicates)
+		}
+		if tt.query == "wooden church" && clusters[0].Score != best {
+			t.Errorf("%s %+v: scored %v, want the best member's %v", tt.query, tt.opts, clusters[0].Score, best)
+		}
+	}
+}
+
+func TestDisambiguationPages(t *testing.T) {
+	dab := testDoc(1, "Mercury (disambiguation)", "mercury may refer to the planet mercury, the element mercury or the god mercury")
+	planet := testDoc(2, "Mercury (planet)", "mercury is the smallest planet and the closest one to the sun")
+	element := testDoc(3, "Mercury (element)", "mercury is a chemical element that is liquid at room temperature")
+	god := testDoc(4, "Mercury (mythology)", "mercury is a roman god of commerce and messages and travellers")
+	other := testDoc(5, "Mercury Records (disambiguation)", "mercury records may be one of several labels")
+	dab.Disambiguation = true
+	other.Disambiguation = true
+	dab.Candidates = []string{"Mercury (element)", "Missing page", "Mercury Records (disambiguation)", "Mercury (planet)", "mercury (element)", "Mercury (mythology)"}
+	bm := withFiller(dab, planet, element, god, other)
+
+	bm.SetWeights(Weights{})
+	plain := scores(t, bm, "mercury", Options{})
+	bm.SetWeights(Weights{Disambiguation: 0.5})
+	penalized := scores(t, bm, "mercury", Options{})
+
+	for title, score := range plain {
+		want := score
+		if title == dab.Title || title == other.Title {
+			want = score * 0.5
+		}
+		if got := penalized[title]; math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s scored %v, want %v", title, got, want)
+		}
+	}
+
+	// the plain name and the full title both list the candidates, in page
+	// order, without the missing page and the other disambiguation page
+	bm.SetWeights(Weights{Disambiguation: 0.9})
+	want := []string{"Mercury (element)", "Mercury (planet)", "Mercury (mythology)"}
+	for _, query := range []string{"mercury", "Mercury (disambiguation)"} {
+		resp, err := bm.SearchWithOptions(query, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, candidate := range resp.DidYouMean {
+			got = append(got, candidate.Title)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%q did you mean %q, want %q", query, got, want)
+		}
+
+		// naming a disambiguation page doesn't pin it above the articles
+		if query == dab.Title && len(resp.Results) > 0 && resp.Results[0].Title == dab.Title {
+			t.Errorf("%q put the disambiguation page first", query)
+		}
+	}
+
+	resp, err := bm.SearchWithOptions("planet", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.DidYouMean != nil {
+		t.Errorf("planet did you mean %+v, want nothing", resp.DidYouMean)
+	}
+}
+
+func TestDisambiguationCandidateLimit(t *testing.T) {
+	dab := testDoc(1, "Paris (disambiguation)", "paris may refer to many places")
+	dab.Disambiguation = true
+
+	docs := []*models.Document{dab}
+	for i := 0; i < maxCandidates+5; i++ {
+		title := fmt.Sprintf("Paris %d", i)
+		docs = append(docs, testDoc(uint32(i+2), title, "a place called paris number "+strconv.Itoa(i)))
+		dab.Candidates = append(dab.Candidates, title)
+	}
+
+	resp, err := testIndex(docs...).SearchWithOptions("paris", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.DidYouMean) != maxCandidates || resp.DidYouMean[0].Title != "Paris 0" {
+		t.Errorf("did you mean %+v, want the first %d", resp.DidYouMean, maxCandidates)
+	}
+}
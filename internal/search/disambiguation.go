@@ -0,0 +1,89 @@
+package search
+
+import (
+	"strings"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// how many articles a "did you mean" block lists
+const maxCandidates = 10
+
+// Candidate is an article a disambiguation page lists
+type Candidate struct {
+	DocID  uint32 `json:"doc_id"`
+	PageID int64  `json:"page_id"`
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+}
+
+// buildDisambiguationIndex maps "mercury" to the "Mercury (disambiguation)"
+// page, so the plain name finds the page even though it isn't its title
+func buildDisambiguationIndex(documents map[uint32]*models.Document) map[string]uint32 {
+	index := make(map[string]uint32)
+	for id, doc := range documents {
+		if doc.Disambiguation {
+			index[models.TitleKey(unqualified(doc.Title))] = id
+		}
+	}
+
+	return index
+}
+
+// unqualified drops a trailing "(...)" from a title
+func unqualified(title string) string {
+	if strings.HasSuffix(title, ")") {
+		if i := strings.LastIndex(title, " ("); i > 0 {
+			return title[:i]
+		}
+	}
+
+	return title
+}
+
+// disambiguationFor finds the disambiguation page a query names exactly,
+// by its title, a redirect to it or its title without the qualifier
+func (bm *BM25) disambiguationFor(query string) *models.Document {
+	key := models.TitleKey(query)
+
+	if id, ok := bm.titles[key]; ok && bm.documents[id].Disambiguation {
+		return bm.documents[id]
+	}
+	if id, ok := bm.disambiguations[key]; ok {
+		return bm.documents[id]
+	}
+
+	return nil
+}
+
+// candidates resolves the titles a disambiguation page lists to articles,
+// in the order the page lists them
+func (bm *BM25) candidates(doc *models.Document) []Candidate {
+	var candidates []Candidate
+	seen := make(map[uint32]bool)
+
+	for _, title := range doc.Candidates {
+		id, ok := bm.titles[models.TitleKey(title)]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		target := bm.documents[id]
+		if target.Disambiguation {
+			continue
+		}
+		candidates = append(candidates, Candidate{
+			DocID:  id,
+			PageID: target.PageID,
+			Title:  target.Title,
+			URL:    target.URL,
+		})
+
+		if len(candidates) == maxCandidates {
+			break
+		}
+	}
+
+	return candidates
+}
@@ -2,22 +2,52 @@ package search
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 
+	"github.com/Adit0507/wiki-search-engine/internal/models"
 	"github.com/Adit0507/wiki-search-engine/internal/storage"
+	"github.com/Adit0507/wiki-search-engine/internal/utils"
 )
 
 type Engine struct {
-	bm25 *BM25
+	bm25    *BM25
+	pageIDs map[int64]uint32
 }
 
 func (e *Engine) Search(query string, limit int) ([]Result, error) {
 	return e.bm25.Search(query, limit)
 }
 
+func (e *Engine) SearchWithOptions(query string, opts Options) (*Response, error) {
+	return e.bm25.SearchWithOptions(query, opts)
+}
+
+// SetWeights changes how static scores like pagerank mix into ranking
+func (e *Engine) SetWeights(w Weights) {
+	e.bm25.SetWeights(w)
+}
+
+// Document looks a document up by its internal id
+func (e *Engine) Document(docID uint32) *models.Document {
+	return e.bm25.documents[docID]
+}
+
+// DocumentByPageID looks a document up by its wikipedia page id
+func (e *Engine) DocumentByPageID(pageID int64) *models.Document {
+	docID, ok := e.pageIDs[pageID]
+	if !ok {
+		return nil
+	}
+
+	return e.bm25.documents[docID]
+}
+
 func NewEngine(indexPath string) (*Engine, error) {
-	storage := storage.NewDiskStorage(indexPath)
+	store := storage.NewDiskStorage(indexPath)
 
 	// load metadata
 	metaFile := filepath.Join(indexPath, "metadata.json")
@@ -34,18 +64,54 @@ func NewEngine(indexPath string) (*Engine, error) {
 	docCount := int(metadata["doc_count"].(float64))
 	avgDocLen := metadata["avg_doc_len"].(float64)
 
+	// queries go through the same analyzer the index was built with
+	langCode, _ := metadata["language"].(string)
+	lang := utils.GetLanguage(langCode)
+
 	// loading documents
-	documents, err := storage.LoadDocuments()
+	documents, err := store.LoadDocuments()
 	if err != nil {
 		return nil, err
 	}
 	// loaidng term index
-	termIndex, err := storage.LoadTermIndex()
+	termIndex, err := store.LoadTermIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	// incremental updates saved since the index was built
+	deltas, err := store.LoadDeltas()
 	if err != nil {
 		return nil, err
 	}
+	if len(deltas) > 0 {
+		fmt.Printf("Applying %d index updates\n", len(deltas))
+		storage.ApplyDeltas(documents, termIndex, deltas, lang)
+
+		totalLen := 0
+		for _, doc := range documents {
+			totalLen += doc.Length
+		}
+		docCount = len(documents)
+		if docCount > 0 {
+			avgDocLen = float64(totalLen) / float64(docCount)
+		}
+	}
+
+	// page id mapping, rebuilt from the documents for indexes that predate it
+	pageIDs, err := store.LoadIDMap()
+	if errors.Is(err, fs.ErrNotExist) {
+		pageIDs = make(map[int64]uint32, len(documents))
+		for id, doc := range documents {
+			if doc.PageID != 0 {
+				pageIDs[doc.PageID] = id
+			}
+		}
+	} else if err != nil {
+		return nil, err
+	}
 
-	bm25 := NewBM25(documents, termIndex, docCount, avgDocLen)
+	bm25 := NewBM25(documents, termIndex, docCount, avgDocLen, lang)
 
-	return &Engine{bm25: bm25}, nil
+	return &Engine{bm25: bm25, pageIDs: pageIDs}, nil
 }
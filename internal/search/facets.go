@@ -0,0 +1,95 @@
+package search
+
+import (
+	"sort"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/utils"
+)
+
+// how many categories a response lists
+const facetCount = 10
+
+// keywordIndex holds the exact match fields, built from the documents when
+// the engine loads
+type keywordIndex struct {
+	categories map[string][]uint32 // TitleKey(category) -> docs
+	fields     map[string][]uint32 // fieldTerm(key, stem) -> docs
+	infoboxes  []uint32            // docs that have an infobox at all
+	anchors    map[string][]uint32 // anchor text stem -> docs
+	anchorLen  float64             // average anchor length
+}
+
+func buildKeywordIndex(documents map[uint32]*models.Document, lang *utils.Language) *keywordIndex {
+	idx := &keywordIndex{
+		categories: make(map[string][]uint32),
+		fields:     make(map[string][]uint32),
+		anchors:    make(map[string][]uint32),
+	}
+
+	anchorTotal := 0
+	for id, doc := range documents {
+		for term := range doc.AnchorTerms {
+			idx.anchors[term] = append(idx.anchors[term], id)
+		}
+		anchorTotal += doc.AnchorLength
+
+		for _, category := range doc.Categories {
+			key := models.TitleKey(category)
+			idx.categories[key] = append(idx.categories[key], id)
+		}
+
+		if doc.Infobox == nil {
+			continue
+		}
+		idx.infoboxes = append(idx.infoboxes, id)
+
+		for key, value := range doc.Infobox.Fields {
+			seen := make(map[string]bool)
+			for _, stem := range analyze(lang, value) {
+				if !seen[stem] {
+					seen[stem] = true
+					idx.fields[fieldTerm(key, stem)] = append(idx.fields[fieldTerm(key, stem)], id)
+				}
+			}
+		}
+	}
+	if len(documents) > 0 {
+		idx.anchorLen = float64(anchorTotal) / float64(len(documents))
+	}
+
+	return idx
+}
+
+func fieldTerm(key, stem string) string {
+	return key + "\x00" + stem
+}
+
+// categoryFacets counts categories over every result, not just the page
+// that gets returned
+func (bm *BM25) categoryFacets(results []Result, n int) []Facet {
+	counts := make(map[string]int)
+	for _, result := range results {
+		for _, category := range bm.documents[result.DocID].Categories {
+			counts[category]++
+		}
+	}
+
+	facets := make([]Facet, 0, len(counts))
+	for category, count := range counts {
+		facets = append(facets, Facet{Category: category, Count: count})
+	}
+
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Category < facets[j].Category
+	})
+
+	if len(facets) > n {
+		facets = facets[:n]
+	}
+
+	return facets
+}
@@ -0,0 +1,103 @@
+package search
+
+import (
+	"time"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+const defaultLimit = 10
+
+// sort orders, relevance is the default
+const (
+	SortRelevance = "relevance"
+	SortModified  = "modified" // newest revision first
+)
+
+// Options narrows a search down and controls what comes back
+type Options struct {
+	Limit      int
+	Categories []string // every one of them must match
+
+	// zero means unbounded
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+
+	Sort string
+
+	// near-duplicates of a better result are collapsed into it unless set
+	KeepDuplicates bool
+}
+
+func (o Options) limit() int {
+	if o.Limit <= 0 {
+		return defaultLimit
+	}
+
+	return o.Limit
+}
+
+// filter decides which candidates may be scored at all
+type filter struct {
+	allowed        map[uint32]bool // nil means no keyword restriction
+	ranges         []rangeClause
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+}
+
+func (bm *BM25) newFilter(opts Options, pq parsedQuery) *filter {
+	f := &filter{
+		ranges:         pq.ranges,
+		modifiedAfter:  opts.ModifiedAfter,
+		modifiedBefore: opts.ModifiedBefore,
+	}
+
+	for _, category := range opts.Categories {
+		f.intersect(bm.keywords.categories[models.TitleKey(category)])
+	}
+
+	for _, clause := range pq.fields {
+		for _, term := range clause.terms {
+			f.intersect(bm.keywords.fields[fieldTerm(clause.key, term)])
+		}
+	}
+
+	// ranges can only match docs with an infobox
+	if len(f.ranges) > 0 {
+		f.intersect(bm.keywords.infoboxes)
+	}
+
+	return f
+}
+
+func (f *filter) intersect(docs []uint32) {
+	next := make(map[uint32]bool, len(docs))
+	for _, docID := range docs {
+		if f.allowed == nil || f.allowed[docID] {
+			next[docID] = true
+		}
+	}
+	f.allowed = next
+}
+
+func (f *filter) matches(doc *models.Document) bool {
+	if f.allowed != nil && !f.allowed[doc.ID] {
+		return false
+	}
+
+	for _, r := range f.ranges {
+		if !r.matches(doc.Infobox) {
+			return false
+		}
+	}
+
+	// documents without a timestamp can't be placed in time
+	if !f.modifiedAfter.IsZero() && !doc.Modified.After(f.modifiedAfter) {
+		return false
+	}
+	if !f.modifiedBefore.IsZero() && !doc.Modified.Before(f.modifiedBefore) {
+		return false
+	}
+
+	return true
+}
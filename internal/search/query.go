@@ -0,0 +1,186 @@
+package search
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/utils"
+)
+
+// infobox.capital:paris, infobox.population:>1000000,
+// infobox.birth_date:1800..1900, infobox.name:"new york"
+var fieldClauseRe = regexp.MustCompile(`(?i)\binfobox\.([\w-]+):("[^"]*"|\S+)`)
+
+type parsedQuery struct {
+	text   string // whatever isn't a field clause
+	fields []fieldClause
+	ranges []rangeClause
+}
+
+// fieldClause needs every term of value in the infobox field
+type fieldClause struct {
+	key   string
+	terms []string
+}
+
+// rangeClause bounds a numeric or date infobox field, either end may be open
+type rangeClause struct {
+	key         string
+	numeric     bool
+	lowN, highN *float64
+	lowD, highD string // yyyy-mm-dd, "" when open
+}
+
+func (bm *BM25) parseQuery(query string) parsedQuery {
+	var pq parsedQuery
+
+	for _, m := range fieldClauseRe.FindAllStringSubmatch(query, -1) {
+		key := models.FieldKey(m[1])
+		value := strings.Trim(m[2], `"`)
+
+		if r, ok := parseRange(key, value); ok {
+			pq.ranges = append(pq.ranges, r)
+			continue
+		}
+
+		clause := fieldClause{key: key, terms: analyze(bm.lang, value)}
+		if len(clause.terms) > 0 {
+			pq.fields = append(pq.fields, clause)
+		}
+	}
+
+	pq.text = strings.TrimSpace(fieldClauseRe.ReplaceAllString(query, " "))
+	return pq
+}
+
+func (pq parsedQuery) hasClauses() bool {
+	return len(pq.fields) > 0 || len(pq.ranges) > 0
+}
+
+// analyze tokenizes and stems the way documents were indexed
+func analyze(lang *utils.Language, text string) []string {
+	var stemmed []string
+	for _, token := range lang.Tokenize(strings.ToLower(text)) {
+		if stem := lang.Stem(token); len(stem) > 2 {
+			stemmed = append(stemmed, stem)
+		}
+	}
+
+	return stemmed
+}
+
+// parseRange understands >x, >=x, <x, <=x and a..b where the bounds are
+// numbers or dates (yyyy, yyyy-mm, yyyy-mm-dd). a plain number or date is
+// the range covering just that
+func parseRange(key, value string) (rangeClause, bool) {
+	r := rangeClause{key: key}
+	low, high := "", ""
+
+	switch {
+	case strings.HasPrefix(value, ">="):
+		low = value[2:]
+	case strings.HasPrefix(value, ">"):
+		low = value[1:]
+	case strings.HasPrefix(value, "<="):
+		high = value[2:]
+	case strings.HasPrefix(value, "<"):
+		high = value[1:]
+	case strings.Contains(value, ".."):
+		low, high, _ = strings.Cut(value, "..")
+	default:
+		if _, ok := parseNumber(value); !ok && dateBound(value, false) == "" {
+			return r, false
+		}
+		low, high = value, value
+	}
+
+	if low == "" && high == "" {
+		return r, false
+	}
+
+	// numbers first, anything with a dash in the middle is a date
+	lowN, lowOK := parseNumber(low)
+	highN, highOK := parseNumber(high)
+	if (low == "" || lowOK) && (high == "" || highOK) && !strings.Contains(strings.TrimPrefix(low+high, "-"), "-") {
+		r.numeric = true
+		if low != "" {
+			r.lowN = &lowN
+		}
+		if high != "" {
+			r.highN = &highN
+		}
+		// a bare year could be either, dates get checked too
+		lowD, highD := dateBound(low, false), dateBound(high, true)
+		if (low == "" || lowD != "") && (high == "" || highD != "") {
+			r.lowD, r.highD = lowD, highD
+		}
+		return r, true
+	}
+
+	r.lowD, r.highD = dateBound(low, false), dateBound(high, true)
+	if (low != "" && r.lowD == "") || (high != "" && r.highD == "") {
+		return r, false
+	}
+
+	return r, true
+}
+
+func parseNumber(s string) (float64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	return n, err == nil
+}
+
+// dateBound expands a partial date to the first or last day it covers
+func dateBound(s string, upper bool) string {
+	parts := strings.Split(s, "-")
+	if s == "" || len(parts) > 3 || len(parts[0]) < 3 || len(parts[0]) > 4 {
+		return ""
+	}
+	if len(parts[0]) == 3 {
+		parts[0] = "0" + parts[0]
+	}
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err != nil {
+			return ""
+		}
+	}
+
+	fill := []string{"01", "01"}
+	if upper {
+		fill = []string{"12", "31"}
+	}
+	for len(parts) < 3 {
+		parts = append(parts, fill[len(parts)-1])
+	}
+	for i := 1; i < 3; i++ {
+		if len(parts[i]) == 1 {
+			parts[i] = "0" + parts[i]
+		}
+	}
+
+	return strings.Join(parts, "-")
+}
+
+func (r rangeClause) matches(box *models.Infobox) bool {
+	if box == nil {
+		return false
+	}
+
+	if r.numeric {
+		if n, ok := box.Numbers[r.key]; ok {
+			return (r.lowN == nil || n >= *r.lowN) && (r.highN == nil || n <= *r.highN)
+		}
+	}
+
+	date, ok := box.Dates[r.key]
+	if !ok || r.lowD == "" && r.highD == "" {
+		return false
+	}
+
+	return (r.lowD == "" || date >= r.lowD) && (r.highD == "" || date <= r.highD)
+}
@@ -0,0 +1,149 @@
+package search
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/utils"
+)
+
+// testIndex searches docs the way the engine does once they're loaded
+func testIndex(docs ...*models.Document) *BM25 {
+	documents := make(map[uint32]*models.Document, len(docs))
+	termIndex := make(map[string][]uint32)
+	total := 0
+	for _, doc := range docs {
+		documents[doc.ID] = doc
+		for term := range doc.Terms {
+			termIndex[term] = append(termIndex[term], doc.ID)
+		}
+		total += doc.Length
+	}
+
+	return NewBM25(documents, termIndex, len(docs), float64(total)/float64(max(len(docs), 1)), utils.English)
+}
+
+func testDoc(id uint32, title, content string) *models.Document {
+	return models.NewDocument(id, title, content, "https://en.wikipedia.org/wiki/"+title, utils.English)
+}
+
+// titles runs a search and lists the titles that came back in order
+func titles(t *testing.T, bm *BM25, query string, opts Options) []string {
+	t.Helper()
+
+	resp, err := bm.SearchWithOptions(query, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found []string
+	for _, result := range resp.Results {
+		found = append(found, result.Title)
+	}
+
+	return found
+}
+
+func TestParseRange(t *testing.T) {
+	n := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		value string
+		want  rangeClause
+		ok    bool
+	}{
+		{value: ">1000000", want: rangeClause{numeric: true, lowN: n(1000000)}, ok: true},
+		{value: "<=2,000", want: rangeClause{numeric: true, highN: n(2000)}, ok: true},
+		{value: "1.5..2.5", want: rangeClause{numeric: true, lowN: n(1.5), highN: n(2.5)}, ok: true},
+		{value: "-10..", want: rangeClause{numeric: true, lowN: n(-10)}, ok: true},
+		{value: "1800..1900", want: rangeClause{numeric: true, lowN: n(1800), highN: n(1900), lowD: "1800-01-01", highD: "1900-12-31"}, ok: true},
+		{value: "1905", want: rangeClause{numeric: true, lowN: n(1905), highN: n(1905), lowD: "1905-01-01", highD: "1905-12-31"}, ok: true},
+		{value: ">=1879-03", want: rangeClause{lowD: "1879-03-01"}, ok: true},
+		{value: "1879-03-14..1955-04", want: rangeClause{lowD: "1879-03-14", highD: "1955-04-31"}, ok: true},
+		{value: "paris", ok: false},
+		{value: "..", ok: false},
+		{value: ">soon", ok: false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRange("key", tt.value)
+		if ok != tt.ok {
+			t.Errorf("parseRange(%q) ok = %v, want %v", tt.value, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		tt.want.key = "key"
+		if got.numeric != tt.want.numeric || !sameBound(got.lowN, tt.want.lowN) || !sameBound(got.highN, tt.want.highN) ||
+			got.lowD != tt.want.lowD || got.highD != tt.want.highD {
+			t.Errorf("parseRange(%q) = %s, want %s", tt.value, describeRange(got), describeRange(tt.want))
+		}
+	}
+}
+
+func sameBound(a, b *float64) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+func describeRange(r rangeClause) string {
+	bound := func(f *float64) string {
+		if f == nil {
+			return "open"
+		}
+		return strconv.FormatFloat(*f, 'g', -1, 64)
+	}
+
+	return fmt.Sprintf("{numeric %v %s..%s dates %q..%q}", r.numeric, bound(r.lowN), bound(r.highN), r.lowD, r.highD)
+}
+
+func TestFieldQueries(t *testing.T) {
+	city := func(id uint32, title, population, founded string, numbers map[string]float64, dates map[string]string) *models.Document {
+		doc := testDoc(id, title, title+" is a city with old streets and a river running through the middle of it")
+		doc.Infobox = &models.Infobox{
+			Name:    "settlement",
+			Fields:  map[string]string{"name": title, "population": population, "founded": founded, "country": "France"},
+			Numbers: numbers,
+			Dates:   dates,
+		}
+		return doc
+	}
+
+	bm := testIndex(
+		city(1, "Paris", "2,140,526", "3rd century BC", map[string]float64{"population": 2140526}, nil),
+		city(2, "Lyon", "522,250", "43 BC", map[string]float64{"population": 522250}, nil),
+		city(3, "Nancy", "104,260", "1050", map[string]float64{"population": 104260, "founded": 1050}, map[string]string{"founded": "1050-01-01"}),
+		city(4, "Brest", "139,926", "1240-05-01", map[string]float64{"population": 139926}, map[string]string{"founded": "1240-05-01"}),
+		testDoc(5, "Seine", "the Seine is a river that runs through Paris, a city in France"),
+	)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"infobox.population:>1000000", []string{"Paris"}},
+		{"infobox.population:100000..200000", []string{"Brest", "Nancy"}},
+		{"infobox.population:<=522,250", []string{"Brest", "Lyon", "Nancy"}},
+		{"infobox.founded:1000..1100", []string{"Nancy"}},
+		{"infobox.founded:1240-05", []string{"Brest"}},
+		{"infobox.founded:>=1200", []string{"Brest"}},
+		{"infobox.name:lyon", []string{"Lyon"}},
+		{`infobox.country:"france" nancy`, []string{"Nancy"}},
+		{`infobox.name:"new york"`, nil},
+		{"infobox.country:france infobox.population:>500000", []string{"Lyon", "Paris"}},
+		{"infobox.country:germany", nil},
+		{"infobox.missing:>1", nil},
+	}
+
+	for _, tt := range tests {
+		got := titles(t, bm, tt.query, Options{})
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s found %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
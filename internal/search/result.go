@@ -1,15 +1,113 @@
 package search
 
+import (
+	"sort"
+	"time"
+)
+
 type Result struct {
-	DocID   uint32  `json:"doc_id"`
-	Title   string  `json:"title"`
-	URL     string  `json:"url"`
-	Score   float64 `json:"score"`
-	Snippet string  `json:"snippet"`
+	DocID      uint32  `json:"doc_id"`
+	PageID     int64   `json:"page_id"`
+	RevisionID int64   `json:"revision_id"`
+	Title      string  `json:"title"`
+	Alias      string  `json:"alias,omitempty"`
+	URL        string  `json:"url"`
+	Section    string  `json:"section,omitempty"`
+	SectionURL string  `json:"section_url,omitempty"`
+	Score      float64 `json:"score"`
+	Snippet    string  `json:"snippet"`
+
+	Modified time.Time `json:"modified,omitzero"`
+
+	Duplicates int `json:"duplicates,omitempty"` // near-duplicates collapsed into this one
+
+	Disambiguation bool `json:"disambiguation,omitempty"`
+}
+
+// Response is a page of results plus what the whole result set looks like
+type Response struct {
+	Results []Result `json:"results"`
+	Total   int      `json:"total"`
+	Facets  []Facet  `json:"facets,omitempty"`
+
+	// articles a disambiguation page the query names lists
+	DidYouMean []Candidate `json:"did_you_mean,omitempty"`
+}
+
+type Facet struct {
+	Category string `json:"category"`
+	Count    int    `json:"count"`
 }
 
 type ResultSet []Result
 
-func (r ResultSet) Len() int           { return len(r) }
-func (r ResultSet) Less(i, j int) bool { return r[i].Score > r[j].Score }
-func (r ResultSet) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r ResultSet) Len() int      { return len(r) }
+func (r ResultSet) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+
+// ties go to the lower doc id so results don't shuffle between runs
+func (r ResultSet) Less(i, j int) bool {
+	if r[i].Score != r[j].Score {
+		return r[i].Score > r[j].Score
+	}
+	return r[i].DocID < r[j].DocID
+}
+
+// sortByModified puts the most recently edited results first, keeping the
+// relevance order among equal timestamps
+func sortByModified(results []Result) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Modified.After(results[j].Modified)
+	})
+}
+
+// collapseDuplicates folds every near-duplicate cluster into one result
+// where its best ranked member was, counting the others on it. the result
+// is the cluster's canonical document with the best member's score, unless
+// that member is the pinned exact title match
+func (bm *BM25) collapseDuplicates(results []Result, terms []string, f *filter, pinned uint32) []Result {
+	matched := make(map[uint32]Result, len(results))
+	for _, result := range results {
+		matched[result.DocID] = result
+	}
+
+	first := make(map[uint32]int)
+	kept := results[:0]
+
+	for _, result := range results {
+		cluster := bm.documents[result.DocID].Canonical
+		if cluster == 0 {
+			kept = append(kept, result)
+			continue
+		}
+
+		if i, ok := first[cluster]; ok {
+			kept[i].Duplicates++
+			continue
+		}
+		first[cluster] = len(kept)
+		kept = append(kept, bm.canonicalResult(result, cluster, matched, terms, f, pinned))
+	}
+
+	return kept
+}
+
+// canonicalResult is what a cluster whose best ranked match is best shows
+func (bm *BM25) canonicalResult(best Result, canonical uint32, matched map[uint32]Result, terms []string, f *filter, pinned uint32) Result {
+	if best.DocID == canonical || best.DocID == pinned {
+		return best
+	}
+
+	shown, ok := matched[canonical]
+	if !ok {
+		// the canonical didn't match the query itself, it still has to
+		// pass the filters, and deleted ones are gone
+		doc := bm.documents[canonical]
+		if doc == nil || !f.matches(doc) {
+			return best
+		}
+		shown = bm.result(doc, 0, terms)
+	}
+	shown.Score = best.Score
+
+	return shown
+}
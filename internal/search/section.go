@@ -0,0 +1,47 @@
+package search
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// bestSection returns the section where the query terms show up most, or
+// nil when that's the lead or the document has no headings
+func (bm *BM25) bestSection(doc *models.Document, terms []string) *models.Section {
+	if len(doc.Sections) < 2 {
+		return nil
+	}
+
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = true
+	}
+
+	best, bestHits := -1, 0
+	for i, section := range doc.Sections {
+		hits := 0
+		for _, token := range bm.lang.Tokenize(section.Text(doc.Content)) {
+			if wanted[bm.lang.Stem(token)] {
+				hits++
+			}
+		}
+
+		if hits > bestHits {
+			best, bestHits = i, hits
+		}
+	}
+
+	if best < 0 || doc.Sections[best].Heading == "" {
+		return nil
+	}
+
+	return &doc.Sections[best]
+}
+
+// sectionURL links straight to a heading, wikipedia anchors are the heading
+// with underscores for spaces
+func sectionURL(docURL, heading string) string {
+	return docURL + "#" + url.PathEscape(strings.ReplaceAll(heading, " ", "_"))
+}
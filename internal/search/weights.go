@@ -0,0 +1,34 @@
+package search
+
+import (
+	"math"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+)
+
+// Weights controls how much the signals other than body bm25 count.
+// 0 turns a signal off
+type Weights struct {
+	PageRank   float64
+	Anchor     float64 // bm25 over incoming link text
+	Popularity float64 // per e-fold of pageviews
+
+	// share of their score disambiguation pages lose
+	Disambiguation float64
+}
+
+func DefaultWeights() Weights {
+	return Weights{PageRank: 1, Anchor: 0.5, Popularity: 0.25, Disambiguation: 0.5}
+}
+
+func (bm *BM25) SetWeights(w Weights) {
+	bm.weights = w
+}
+
+// staticScore is added to the bm25 score of every match. pagerank averages
+// 1 and pageviews run into the millions, the logs keep hub pages and
+// popular ones from drowning out the text
+func (bm *BM25) staticScore(doc *models.Document) float64 {
+	return bm.weights.PageRank*math.Log1p(doc.PageRank) +
+		bm.weights.Popularity*math.Log1p(float64(doc.Popularity))
+}
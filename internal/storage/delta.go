@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/Adit0507/wiki-search-engine/internal/models"
+	"github.com/Adit0507/wiki-search-engine/internal/utils"
+)
+
+// Delta is one incremental update, saved next to the base index instead
+// of rewriting it. deltas are applied in the order they were saved
+type Delta struct {
+	Documents map[uint32]*models.Document // added or replaced, a replacement keeps the old id
+	Deleted   []uint32
+	Aliases   map[uint32][]string // new redirects to documents the delta doesn't carry
+}
+
+func (ds *DiskStorage) deltaFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(ds.indexPath, "delta-*.gob"))
+	sort.Strings(files)
+
+	return files, err
+}
+
+// SaveDelta writes the update after the ones already there
+func (ds *DiskStorage) SaveDelta(delta *Delta) error {
+	files, err := ds.deltaFiles()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(ds.indexPath, fmt.Sprintf("delta-%06d.gob", len(files)+1))
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(file).Encode(delta); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func (ds *DiskStorage) LoadDeltas() ([]*Delta, error) {
+	files, err := ds.deltaFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	deltas := make([]*Delta, 0, len(files))
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var delta Delta
+		err = gob.NewDecoder(file).Decode(&delta)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		deltas = append(deltas, &delta)
+	}
+
+	return deltas, nil
+}
+
+// RemoveDeltas is called once the base index has them folded in, or was
+// rebuilt from scratch
+func (ds *DiskStorage) RemoveDeltas() error {
+	files, err := ds.deltaFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range files {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SaveLengths stores doc id -> length, all an update needs to keep the
+// average document length right without loading the documents
+func (ds *DiskStorage) SaveLengths(lengths map[uint32]int) error {
+	file, err := os.Create(filepath.Join(ds.indexPath, "lengths.gob"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := gob.NewEncoder(file)
+	return encoder.Encode(lengths)
+}
+
+func (ds *DiskStorage) LoadLengths() (map[uint32]int, error) {
+	lengths := make(map[uint32]int)
+	streamed, err := ds.readStream("lengths.gob", func(decoder *gob.Decoder) error {
+		var entry LengthEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return err
+		}
+		lengths[entry.ID] = entry.Length
+		return nil
+	})
+	if streamed || err != nil {
+		return lengths, err
+	}
+
+	file, err := os.Open(filepath.Join(ds.indexPath, "lengths.gob"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := gob.NewDecoder(file)
+	err = decoder.Decode(&lengths)
+
+	return lengths, err
+}
+
+// SaveTitles stores title key -> doc id, so an update can attach redirects
+// to articles it doesn't have itself
+func (ds *DiskStorage) SaveTitles(titles map[string]uint32) error {
+	file, err := os.Create(filepath.Join(ds.indexPath, "titles.gob"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := gob.NewEncoder(file)
+	return encoder.Encode(titles)
+}
+
+func (ds *DiskStorage) LoadTitles() (map[string]uint32, error) {
+	titles := make(map[string]uint32)
+	streamed, err := ds.readStream("titles.gob", func(decoder *gob.Decoder) error {
+		var entry TitleEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return err
+		}
+		titles[entry.Key] = entry.ID
+		return nil
+	})
+	if streamed || err != nil {
+		return titles, err
+	}
+
+	file, err := os.Open(filepath.Join(ds.indexPath, "titles.gob"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := gob.NewDecoder(file)
+	err = decoder.Decode(&titles)
+
+	return titles, err
+}
+
+// ApplyDeltas merges the updates into a loaded base index in place. a
+// replaced document keeps the pagerank, pageviews, anchors and redirects of
+// the one before it, those come from the rest of the wiki and only get
+// recomputed by a full rebuild
+func ApplyDeltas(documents map[uint32]*models.Document, termIndex map[string][]uint32, deltas []*Delta, lang *utils.Language) {
+	base := make(map[uint32]*models.Document)
+	final := make(map[uint32]*models.Document)
+
+	for _, delta := range deltas {
+		for _, id := range delta.Deleted {
+			if doc, ok := documents[id]; ok && final[id] == nil {
+				base[id] = doc
+			}
+			delete(documents, id)
+			delete(final, id)
+		}
+
+		for id, doc := range delta.Documents {
+			if old, ok := documents[id]; ok {
+				doc.Inherit(old, lang)
+				if final[id] == nil {
+					base[id] = old
+				}
+			}
+			documents[id] = doc
+			final[id] = doc
+		}
+
+		for id, aliases := range delta.Aliases {
+			doc, ok := documents[id]
+			if !ok {
+				continue
+			}
+
+			// the document's own postings are added below when it's new
+			doc.SetLanguage(lang)
+			for _, alias := range aliases {
+				if slices.Contains(doc.Aliases, alias) {
+					continue
+				}
+				for _, term := range doc.AddAlias(alias) {
+					if final[id] == nil {
+						termIndex[term] = append(termIndex[term], id)
+					}
+				}
+			}
+		}
+	}
+
+	// drop the postings of whatever was replaced or deleted, then post the
+	// documents that replaced them
+	affected := make(map[string]bool)
+	for _, doc := range base {
+		for term := range doc.Terms {
+			affected[term] = true
+		}
+	}
+	for term := range affected {
+		postings := termIndex[term][:0]
+		for _, id := range termIndex[term] {
+			if base[id] == nil {
+				postings = append(postings, id)
+			}
+		}
+
+		if len(postings) == 0 {
+			delete(termIndex, term)
+		} else {
+			termIndex[term] = postings
+		}
+	}
+
+	for id, doc := range final {
+		for term := range doc.Terms {
+			termIndex[term] = append(termIndex[term], id)
+		}
+	}
+}
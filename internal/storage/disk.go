@@ -2,6 +2,8 @@ package storage
 
 import (
 	"encoding/gob"
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
 
@@ -39,13 +41,25 @@ func (ds *DiskStorage) SaveTermIndex(termIndex map[string][]uint32) error {
 }
 
 func (ds *DiskStorage) LoadDocuments() (map[uint32]*models.Document, error) {
+	documents := make(map[uint32]*models.Document)
+	streamed, err := ds.readStream("documents.gob", func(decoder *gob.Decoder) error {
+		var doc models.Document
+		if err := decoder.Decode(&doc); err != nil {
+			return err
+		}
+		documents[doc.ID] = &doc
+		return nil
+	})
+	if streamed || err != nil {
+		return documents, err
+	}
+
 	file, err := os.Open(filepath.Join(ds.indexPath, "documents.gob"))
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	var documents map[uint32]*models.Document
 	decoder := gob.NewDecoder(file)
 	err = decoder.Decode(&documents)
 
@@ -53,15 +67,129 @@ func (ds *DiskStorage) LoadDocuments() (map[uint32]*models.Document, error) {
 }	
 
 func (ds *DiskStorage) LoadTermIndex() (map[string][]uint32, error) {
+	termIndex := make(map[string][]uint32)
+	streamed, err := ds.readStream("terms.gob", func(decoder *gob.Decoder) error {
+		var posting Posting
+		if err := decoder.Decode(&posting); err != nil {
+			return err
+		}
+		termIndex[posting.Term] = posting.Docs
+		return nil
+	})
+	if streamed || err != nil {
+		return termIndex, err
+	}
+
 	file, err := os.Open(filepath.Join(ds.indexPath, "terms.gob"))
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	var termIndex map[string][]uint32
 	decoder := gob.NewDecoder(file)
 	err = decoder.Decode(&termIndex)
 
 	return termIndex, err
-}
\ No newline at end of file
+}
+
+// SaveIDMap stores the wikipedia page id -> internal doc id mapping
+func (ds *DiskStorage) SaveIDMap(ids map[int64]uint32) error {
+	file, err := os.Create(filepath.Join(ds.indexPath, "ids.gob"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := gob.NewEncoder(file)
+	return encoder.Encode(ids)
+}
+
+func (ds *DiskStorage) LoadIDMap() (map[int64]uint32, error) {
+	ids := make(map[int64]uint32)
+	streamed, err := ds.readStream("ids.gob", func(decoder *gob.Decoder) error {
+		var entry PageIDEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return err
+		}
+		ids[entry.PageID] = entry.ID
+		return nil
+	})
+	if streamed || err != nil {
+		return ids, err
+	}
+
+	file, err := os.Open(filepath.Join(ds.indexPath, "ids.gob"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := gob.NewDecoder(file)
+	err = decoder.Decode(&ids)
+
+	return ids, err
+}
+
+// Checkpoint is a snapshot of a build that was still parsing its input
+type Checkpoint struct {
+	Documents   map[uint32]*models.Document
+	TermIndex   map[string][]uint32
+	Redirects   map[string]string
+	PageIDs     map[int64]uint32
+	NextID      uint32
+	Progress    map[string]int64 // input stream -> offset parsed up to
+	Skipped     map[string]int64 // skip reason -> pages
+	Quarantined int64            // bytes of the quarantine file the skips above wrote
+	Segments    int              // segments flushed, Documents only has what came after
+	DocCount    int              // documents in the segments and in Documents
+	Language    string
+	URLBase     string
+}
+
+// SaveCheckpoint writes to a temp file first so a crash halfway through
+// never clobbers the previous checkpoint
+func (ds *DiskStorage) SaveCheckpoint(cp *Checkpoint) error {
+	path := filepath.Join(ds.indexPath, "checkpoint.gob")
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(file).Encode(cp); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func (ds *DiskStorage) LoadCheckpoint() (*Checkpoint, error) {
+	file, err := os.Open(filepath.Join(ds.indexPath, "checkpoint.gob"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cp Checkpoint
+	decoder := gob.NewDecoder(file)
+	err = decoder.Decode(&cp)
+
+	return &cp, err
+}
+
+func (ds *DiskStorage) RemoveCheckpoint() error {
+	err := os.Remove(filepath.Join(ds.indexPath, "checkpoint.gob"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// documents.gob and terms.gob are either one gob map, as SaveDocuments and
+// SaveTermIndex write them, or a StreamHeader followed by one value per
+// document or term. builds write the stream, they never hold the whole map.
+// ids.gob, lengths.gob and titles.gob are the same, updates still write
+// those as maps
+
+type StreamHeader struct {
+	Kind string // "documents", "terms", "ids", "lengths" or "titles"
+}
+
+// Posting is one term of a streamed term index
+type Posting struct {
+	Term string
+	Docs []uint32
+}
+
+// PageIDEntry is one page of a streamed ids.gob
+type PageIDEntry struct {
+	PageID int64
+	ID     uint32
+}
+
+// LengthEntry is one document of a streamed lengths.gob
+type LengthEntry struct {
+	ID     uint32
+	Length int
+}
+
+// TitleEntry is one title key of a streamed titles.gob, a later entry for
+// the same key wins
+type TitleEntry struct {
+	Key string
+	ID  uint32
+}
+
+type StreamWriter struct {
+	file    *os.File
+	buf     *bufio.Writer
+	encoder *gob.Encoder
+}
+
+// CreateDocumentStream starts a streamed documents.gob, write a
+// *models.Document at a time
+func (ds *DiskStorage) CreateDocumentStream() (*StreamWriter, error) {
+	return createStream(filepath.Join(ds.indexPath, "documents.gob"), "documents")
+}
+
+// CreateTermStream starts a streamed terms.gob, write a Posting at a time
+func (ds *DiskStorage) CreateTermStream() (*StreamWriter, error) {
+	return createStream(filepath.Join(ds.indexPath, "terms.gob"), "terms")
+}
+
+// CreateIDStream starts a streamed ids.gob, write a PageIDEntry at a time
+func (ds *DiskStorage) CreateIDStream() (*StreamWriter, error) {
+	return createStream(filepath.Join(ds.indexPath, "ids.gob"), "ids")
+}
+
+// CreateLengthStream starts a streamed lengths.gob, write a LengthEntry at
+// a time
+func (ds *DiskStorage) CreateLengthStream() (*StreamWriter, error) {
+	return createStream(filepath.Join(ds.indexPath, "lengths.gob"), "lengths")
+}
+
+// CreateTitleStream starts a streamed titles.gob, write a TitleEntry at a
+// time
+func (ds *DiskStorage) CreateTitleStream() (*StreamWriter, error) {
+	return createStream(filepath.Join(ds.indexPath, "titles.gob"), "titles")
+}
+
+func createStream(path, kind string) (*StreamWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &StreamWriter{file: file, buf: bufio.NewWriterSize(file, 1<<20)}
+	w.encoder = gob.NewEncoder(w.buf)
+	if err := w.encoder.Encode(StreamHeader{Kind: kind}); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *StreamWriter) Write(v any) error {
+	return w.encoder.Encode(v)
+}
+
+func (w *StreamWriter) Close() error {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// readStream calls fn until the stream is exhausted. it returns false
+// without an error when the file is a plain map instead
+func (ds *DiskStorage) readStream(name string, fn func(decoder *gob.Decoder) error) (bool, error) {
+	file, err := os.Open(filepath.Join(ds.indexPath, name))
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	decoder := gob.NewDecoder(bufio.NewReaderSize(file, 1<<20))
+	var header StreamHeader
+	if err := decoder.Decode(&header); err != nil {
+		return false, nil
+	}
+
+	for {
+		err := fn(decoder)
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/kljensen/snowball"
+	"github.com/kljensen/snowball/french"
+	"github.com/kljensen/snowball/hungarian"
+	"github.com/kljensen/snowball/norwegian"
+	"github.com/kljensen/snowball/russian"
+	"github.com/kljensen/snowball/spanish"
+	"github.com/kljensen/snowball/swedish"
+)
+
+// Language bundles the stemmer and stopwords used for one wiki language
+type Language struct {
+	Code       string
+	stemmer    string // snowball language, "" leaves words unstemmed
+	isStopWord func(word string) bool
+}
+
+var (
+	English = &Language{Code: "en", stemmer: "english", isStopWord: func(w string) bool { return stopWords[w] }}
+
+	languages = map[string]*Language{
+		"en":     English,
+		"simple": English,
+		"de":     {Code: "de", isStopWord: func(w string) bool { return germanStopWords[w] }},
+		"fr":     {Code: "fr", stemmer: "french", isStopWord: french.IsStopWord},
+		"es":     {Code: "es", stemmer: "spanish", isStopWord: spanish.IsStopWord},
+		"ru":     {Code: "ru", stemmer: "russian", isStopWord: russian.IsStopWord},
+		"sv":     {Code: "sv", stemmer: "swedish", isStopWord: swedish.IsStopWord},
+		"no":     {Code: "no", stemmer: "norwegian", isStopWord: norwegian.IsStopWord},
+		"hu":     {Code: "hu", stemmer: "hungarian", isStopWord: hungarian.IsStopWord},
+	}
+
+	// snowball has no german stemmer, so german only gets stopwords
+	germanStopWords = map[string]bool{
+		"der": true, "die": true, "das": true, "und": true, "ist": true,
+		"den": true, "dem": true, "des": true, "ein": true, "eine": true,
+		"einer": true, "eines": true, "einem": true, "einen": true, "nicht": true,
+		"mit": true, "von": true, "für": true, "auf": true, "sich": true,
+		"als": true, "auch": true, "aus": true, "bei": true, "nach": true,
+		"wie": true, "wird": true, "wurde": true, "wurden": true, "werden": true,
+		"sind": true, "war": true, "waren": true, "hat": true, "haben": true,
+		"oder": true, "aber": true, "noch": true, "nur": true, "über": true,
+		"unter": true, "durch": true, "vom": true, "zum": true, "zur": true,
+		"bis": true, "seit": true, "sein": true, "seine": true, "seiner": true,
+		"ihre": true, "ihr": true, "sie": true, "ich": true, "wir": true,
+		"dass": true, "diese": true, "dieser": true, "dieses": true, "kann": true,
+		"man": true, "sowie": true, "zwischen": true, "gegen": true, "ohne": true,
+		"wenn": true, "dann": true, "denn": true, "weil": true, "sehr": true,
+		"mehr": true, "schon": true, "immer": true, "hier": true, "dort": true,
+	}
+)
+
+// GetLanguage returns the analyzer for a wiki language code. unknown
+// languages are tokenized but neither stemmed nor stopword filtered
+func GetLanguage(code string) *Language {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return English
+	}
+	if lang, ok := languages[code]; ok {
+		return lang
+	}
+
+	return &Language{Code: code, isStopWord: func(string) bool { return false }}
+}
+
+func (l *Language) Stem(word string) string {
+	if l.stemmer == "" {
+		return strings.ToLower(word)
+	}
+
+	stemmed, err := snowball.Stem(word, l.stemmer, true)
+	if err != nil {
+		return strings.ToLower(word)
+	}
+
+	return stemmed
+}
@@ -2,17 +2,10 @@ package utils
 
 import (
 	"strings"
-
-	"github.com/kljensen/snowball"
 )
 
 func Stem(word string) string {
-	stemmed, err := snowball.Stem(word, "english", true)
-	if err != nil{
-		return strings.ToLower(word)
-	}
-
-	return stemmed
+	return English.Stem(word)
 }
 // porter stemming
 func simpleStem(word string) string {
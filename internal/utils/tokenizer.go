@@ -1,14 +1,12 @@
 package utils
 
 import (
-	"regexp"
 	"strings"
 	"unicode"
 )
 
 var (
-	tokenizeRe = regexp.MustCompile(`\b\w+\b`)
-	stopWords  = map[string]bool{
+	stopWords = map[string]bool{
 		"the": true, "be": true, "to": true, "of": true, "and": true,
 		"a": true, "in": true, "that": true, "have": true, "i": true,
 		"it": true, "for": true, "not": true, "on": true, "with": true,
@@ -33,6 +31,10 @@ var (
 )
 
 func Tokenize(text string) []string {
+	return English.Tokenize(text)
+}
+
+func (l *Language) Tokenize(text string) []string {
 	text = strings.ToLower(text) //lowercase
 
 	//removing non letter characters except spaceas
@@ -45,12 +47,12 @@ func Tokenize(text string) []string {
 		}
 	}
 
-	// extractin words
-	tokens := tokenizeRe.FindAllString(result.String(), -1)
+	// extractin words, everything but letters is a space by now
+	tokens := strings.Fields(result.String())
 
 	filtered := make([]string, 0, len(tokens))//filtering out stopwords and very short words
 	for _, token := range tokens {
-		if len(token) > 2 && !stopWords[token] {
+		if len(token) > 2 && !l.isStopWord(token) {
 			filtered = append(filtered, token)
 		}
 	}
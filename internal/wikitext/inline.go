@@ -0,0 +1,163 @@
+package wikitext
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var externalSchemes = []string{"http://", "https://", "//", "ftp://", "mailto:", "irc://", "news:"}
+
+// inline turns links and bold/italic markup into plain text, recording
+// every link it finds on the page
+func (st *state) inline(s string) string {
+	var b strings.Builder
+
+	links := newClosers(s, "[[", "]]")
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "[["):
+			end := links.at(i)
+			if end < 0 {
+				i += 2
+				continue
+			}
+
+			// letters right after the link belong to it, as in [[bus]]es
+			trail := end
+			for trail < len(s) {
+				r, size := utf8.DecodeRuneInString(s[trail:])
+				if !unicode.IsLetter(r) {
+					break
+				}
+				trail += size
+			}
+
+			b.WriteString(st.link(s[i+2:end-2], s[end:trail]))
+			i = trail
+
+		case s[i] == '[' && isExternal(s[i+1:]):
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				b.WriteByte('[')
+				i++
+				continue
+			}
+			// [http://example.com label] shows only the label
+			if _, label, found := strings.Cut(s[i+1:i+end], " "); found {
+				b.WriteString(st.inline(label))
+			}
+			i += end + 1
+
+		case strings.HasPrefix(s[i:], "''"):
+			n := 2
+			for i+n < len(s) && s[i+n] == '\'' {
+				n++
+			}
+			switch {
+			case n == 4:
+				b.WriteByte('\'')
+			case n > 5:
+				b.WriteString(strings.Repeat("'", n-5))
+			}
+			i += n
+
+		default:
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+// link handles the inside of [[...]] and returns the text it displays
+func (st *state) link(inner, trail string) string {
+	colon := strings.HasPrefix(inner, ":")
+	inner = strings.TrimPrefix(inner, ":")
+
+	target, label, piped := inner, "", false
+	if i := indexTopLevel(inner, '|'); i >= 0 {
+		target, label, piped = inner[:i], inner[i+1:], true
+	}
+	target = strings.TrimSpace(target)
+
+	ns, title := st.p.Namespace(target)
+	if prefix, _, found := strings.Cut(target, ":"); found && ns == NSMain {
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if interwikiPrefixes[prefix] {
+			return st.labelText(label, piped, target) + trail
+		}
+		// language links only show up in the sidebar
+		if interwikiRe.MatchString(prefix) {
+			if colon {
+				return st.labelText(label, piped, target) + trail
+			}
+			return ""
+		}
+	}
+
+	if colon && ns != NSMain {
+		// [[:Category:Foo]] is a plain link, not a membership
+		return st.labelText(label, piped, target) + trail
+	}
+
+	if !colon {
+		switch ns {
+		case NSFile, NSMedia:
+			return ""
+		case NSCategory:
+			st.page.Links = append(st.page.Links, Link{
+				Target:    NormalizeTitle(title),
+				Label:     strings.TrimSpace(label),
+				Namespace: ns,
+			})
+			return ""
+		}
+	}
+
+	title, fragment, _ := strings.Cut(title, "#")
+
+	text := st.labelText(label, piped, target)
+	if piped && strings.TrimSpace(label) == "" {
+		// the pipe trick: [[Paris (band)|]] shows "Paris"
+		text = title
+		if i := strings.Index(text, " ("); i > 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+	}
+	text += trail
+
+	// [[#History]] points back at the same page
+	if strings.TrimSpace(title) == "" {
+		return text
+	}
+
+	st.page.Links = append(st.page.Links, Link{
+		Target:    NormalizeTitle(title),
+		Fragment:  strings.TrimSpace(fragment),
+		Label:     strings.TrimSpace(text),
+		Namespace: ns,
+	})
+
+	return text
+}
+
+func (st *state) labelText(label string, piped bool, target string) string {
+	if piped {
+		return st.inline(label)
+	}
+
+	return target
+}
+
+func isExternal(s string) bool {
+	for _, scheme := range externalSchemes {
+		if len(s) >= len(scheme) && strings.EqualFold(s[:len(scheme)], scheme) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+package wikitext
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// namespace ids shared by every mediawiki install
+const (
+	NSMedia    = -2
+	NSSpecial  = -1
+	NSMain     = 0
+	NSUser     = 2
+	NSProject  = 4
+	NSFile     = 6
+	NSTemplate = 10
+	NSHelp     = 12
+	NSCategory = 14
+)
+
+type Page struct {
+	Text       string
+	Paragraphs []string
+	Headings   []Heading
+	Links      []Link
+	Templates  []Template
+	Refs       []Ref
+}
+
+type Heading struct {
+	Title  string
+	Level  int
+	Offset int // byte offset of the heading paragraph in Text
+}
+
+type Link struct {
+	Target    string // normalized title without namespace prefix or fragment
+	Fragment  string
+	Label     string
+	Namespace int
+}
+
+type Template struct {
+	Name string
+	Args []Arg
+}
+
+type Arg struct {
+	Name  string // empty for positional args
+	Value string // plain text
+	Raw   string // unparsed wikitext
+}
+
+type Ref struct {
+	Name string
+	Text string
+}
+
+// Get returns the value of a named argument
+func (t *Template) Get(name string) (string, bool) {
+	for _, arg := range t.Args {
+		if strings.EqualFold(arg.Name, name) {
+			return arg.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Positional returns the i-th unnamed argument, counting from 0
+func (t *Template) Positional(i int) string {
+	for _, arg := range t.Args {
+		if arg.Name != "" {
+			continue
+		}
+		if i == 0 {
+			return arg.Value
+		}
+		i--
+	}
+
+	return ""
+}
+
+// NormalizeTitle applies mediawiki's title rules: underscores are spaces,
+// runs of whitespace collapse and the first letter is uppercase
+func NormalizeTitle(title string) string {
+	title = strings.Join(strings.Fields(strings.ReplaceAll(title, "_", " ")), " ")
+	if title == "" {
+		return ""
+	}
+
+	r, size := utf8.DecodeRuneInString(title)
+	return string(unicode.ToUpper(r)) + title[size:]
+}
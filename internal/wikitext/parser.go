@@ -0,0 +1,340 @@
+package wikitext
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	commentRe    = regexp.MustCompile(`(?s)<!--.*?(?:-->|$)`)
+	magicWordRe  = regexp.MustCompile(`__[A-Z]+__`)
+	emptyParenRe = regexp.MustCompile(`\(\s*[,;:]?\s*\)`)
+	headingRe    = regexp.MustCompile(`^(={1,6})(.+?)(={1,6})$`)
+	interwikiRe  = regexp.MustCompile(`^[a-z]{2,3}(-[a-z]+)*$`)
+	nowikiEscape = strings.NewReplacer(
+		"&", "&amp;", "<", "&lt;", ">", "&gt;", "[", "&#91;", "]", "&#93;",
+		"{", "&#123;", "}", "&#125;", "|", "&#124;", "'", "&#39;", "=", "&#61;",
+		"*", "&#42;", "#", "&#35;", ":", "&#58;", ";", "&#59;", "!", "&#33;",
+	)
+
+	// canonical names, valid on every wiki regardless of language
+	defaultNamespaces = map[string]int{
+		"media":          NSMedia,
+		"special":        NSSpecial,
+		"talk":           1,
+		"user":           NSUser,
+		"user talk":      3,
+		"wikipedia":      NSProject,
+		"project":        NSProject,
+		"wikipedia talk": 5,
+		"file":           NSFile,
+		"image":          NSFile,
+		"file talk":      7,
+		"mediawiki":      8,
+		"template":       NSTemplate,
+		"template talk":  11,
+		"help":           NSHelp,
+		"category":       NSCategory,
+		"category talk":  15,
+		"portal":         100,
+		"draft":          118,
+		"module":         828,
+	}
+
+	// sister projects, linked inline but never article links
+	interwikiPrefixes = map[string]bool{
+		"wikt": true, "wiktionary": true, "commons": true, "c": true,
+		"wikisource": true, "s": true, "wikiquote": true, "q": true,
+		"wikibooks": true, "b": true, "wikinews": true, "n": true,
+		"wikiversity": true, "v": true, "wikivoyage": true, "voy": true,
+		"wikidata": true, "d": true, "species": true, "meta": true,
+		"m": true, "mw": true, "w": true,
+	}
+
+	defaultParser = NewParser(nil)
+)
+
+type Parser struct {
+	namespaces map[string]int
+}
+
+// NewParser builds a parser that knows the given namespace names on top of
+// the canonical english ones. names are matched case-insensitively
+func NewParser(namespaces map[string]int) *Parser {
+	p := &Parser{namespaces: make(map[string]int, len(defaultNamespaces)+len(namespaces))}
+	for name, id := range defaultNamespaces {
+		p.namespaces[name] = id
+	}
+	for name, id := range namespaces {
+		if name = namespaceKey(name); name != "" {
+			p.namespaces[name] = id
+		}
+	}
+
+	return p
+}
+
+func Parse(src string) *Page {
+	return defaultParser.Parse(src)
+}
+
+func (p *Parser) Parse(src string) *Page {
+	st := &state{p: p, page: &Page{}}
+
+	src = commentRe.ReplaceAllString(src, "")
+	src = magicWordRe.ReplaceAllString(src, "")
+	st.blocks(st.expand(src))
+	st.page.Text = st.text.String()
+
+	// after the text's own links, so those stay in page order
+	st.page.Links = append(st.page.Links, st.argLinks...)
+
+	return st.page
+}
+
+// Namespace splits a title into its namespace id and the remaining title
+func (p *Parser) Namespace(title string) (int, string) {
+	prefix, rest, found := strings.Cut(title, ":")
+	if !found {
+		return NSMain, title
+	}
+	if id, ok := p.namespaces[namespaceKey(prefix)]; ok {
+		return id, strings.TrimSpace(rest)
+	}
+
+	return NSMain, title
+}
+
+func namespaceKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(name, "_", " ")), " "))
+}
+
+type state struct {
+	p     *Parser
+	page  *Page
+	text  strings.Builder
+	para  []string
+	table int
+
+	argLinks []Link // found in template arguments, see nested
+}
+
+// blocks walks the expanded source line by line, splitting it into
+// headings, paragraphs, list items and table cells
+func (st *state) blocks(src string) {
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "":
+			st.flush()
+
+		case strings.HasPrefix(line, "{|"):
+			st.flush()
+			st.table++
+
+		case st.table > 0 && strings.HasPrefix(line, "|}"):
+			st.flush()
+			st.table--
+
+		case st.table > 0 && strings.HasPrefix(line, "|-"):
+			st.flush()
+
+		case st.table > 0 && strings.HasPrefix(line, "|+"):
+			st.flush()
+			st.add(cellContent(line[2:]))
+			st.flush()
+
+		case st.table > 0 && (line[0] == '|' || line[0] == '!'):
+			for _, cell := range splitCells(line[1:], line[0] == '!') {
+				st.add(cellContent(cell))
+			}
+
+		case strings.Trim(line, "=") == "":
+			// a bare "===" is neither a heading nor text
+			st.flush()
+
+		case headingRe.MatchString(line):
+			m := headingRe.FindStringSubmatch(line)
+			level := min(len(m[1]), len(m[3]))
+			st.heading(strings.Repeat("=", len(m[1])-level)+m[2]+strings.Repeat("=", len(m[3])-level), level)
+
+		case strings.HasPrefix(line, "----"):
+			st.flush()
+
+		case strings.ContainsRune("*#:;", rune(line[0])):
+			st.flush()
+			st.add(strings.TrimLeft(line, "*#:;"))
+			st.flush()
+
+		default:
+			st.add(line)
+		}
+	}
+
+	st.flush()
+}
+
+func (st *state) add(line string) {
+	st.para = append(st.para, st.inline(line))
+}
+
+func (st *state) flush() {
+	if len(st.para) == 0 {
+		return
+	}
+
+	st.paragraph(strings.Join(st.para, " "))
+	st.para = st.para[:0]
+}
+
+func (st *state) paragraph(text string) int {
+	// removed templates tend to leave "()" behind
+	text = emptyParenRe.ReplaceAllString(html.UnescapeString(text), "")
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return -1
+	}
+
+	if st.text.Len() > 0 {
+		st.text.WriteByte('\n')
+	}
+	offset := st.text.Len()
+	st.text.WriteString(text)
+	st.page.Paragraphs = append(st.page.Paragraphs, text)
+
+	return offset
+}
+
+func (st *state) heading(raw string, level int) {
+	st.flush()
+
+	title := strings.Join(strings.Fields(html.UnescapeString(st.inline(raw))), " ")
+	offset := st.paragraph(title)
+	if offset < 0 {
+		return
+	}
+
+	st.page.Headings = append(st.page.Headings, Heading{Title: title, Level: level, Offset: offset})
+}
+
+// splitCells splits a table row on "||" (and "!!" for header rows),
+// ignoring pipes inside links
+func splitCells(row string, header bool) []string {
+	var cells []string
+	depth, start := 0, 0
+
+	for i := 0; i < len(row); i++ {
+		switch {
+		case strings.HasPrefix(row[i:], "[["):
+			depth++
+			i++
+		case strings.HasPrefix(row[i:], "]]") && depth > 0:
+			depth--
+			i++
+		case depth == 0 && (strings.HasPrefix(row[i:], "||") || header && strings.HasPrefix(row[i:], "!!")):
+			cells = append(cells, row[start:i])
+			start = i + 2
+			i++
+		}
+	}
+
+	return append(cells, row[start:])
+}
+
+// cellContent drops the attribute part of a cell like `style="x" | text`
+func cellContent(cell string) string {
+	if i := indexTopLevel(cell, '|'); i >= 0 && strings.Contains(cell[:i], "=") {
+		return cell[i+1:]
+	}
+
+	return cell
+}
+
+// indexTopLevel finds the first c outside of [[...]] and {{...}}
+func indexTopLevel(s string, c byte) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "[[") || strings.HasPrefix(s[i:], "{{"):
+			depth++
+			i++
+		case (strings.HasPrefix(s[i:], "]]") || strings.HasPrefix(s[i:], "}}")) && depth > 0:
+			depth--
+			i++
+		case depth == 0 && s[i] == c:
+			return i
+		}
+	}
+
+	return -1
+}
+
+// splitTopLevel splits on c outside of [[...]] and {{...}}
+func splitTopLevel(s string, c byte) []string {
+	var parts []string
+	for {
+		i := indexTopLevel(s, c)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// closers pairs every open delimiter in s with the index just past the
+// delimiter that closes it, honouring nesting. it's one pass, scanning
+// ahead from every open is quadratic on the stray {{ and [[ of vandalised
+// or truncated pages
+type closers struct {
+	s, open, close string
+	paired         bool
+	opens, ends    []int // ends[i] closes opens[i], -1 when unbalanced
+	next           int   // lookups go left to right
+}
+
+func newClosers(s, open, close string) *closers {
+	return &closers{s: s, open: open, close: close}
+}
+
+// at returns the index just past the close of the open at s[start:], or
+// -1 when it's unbalanced. starts have to come in increasing order
+func (c *closers) at(start int) int {
+	if !c.paired {
+		c.pair()
+	}
+
+	for c.next < len(c.opens) && c.opens[c.next] < start {
+		c.next++
+	}
+	if c.next < len(c.opens) && c.opens[c.next] == start {
+		return c.ends[c.next]
+	}
+
+	return -1
+}
+
+func (c *closers) pair() {
+	c.paired = true
+
+	var stack []int
+	for i := 0; i < len(c.s); {
+		switch {
+		case strings.HasPrefix(c.s[i:], c.open):
+			stack = append(stack, len(c.opens))
+			c.opens = append(c.opens, i)
+			c.ends = append(c.ends, -1)
+			i += len(c.open)
+		case strings.HasPrefix(c.s[i:], c.close):
+			i += len(c.close)
+			if n := len(stack); n > 0 {
+				c.ends[stack[n-1]] = i
+				stack = stack[:n-1]
+			}
+		default:
+			i++
+		}
+	}
+}
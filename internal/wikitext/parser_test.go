@@ -0,0 +1,167 @@
+package wikitext
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		text     string
+		links    []Link
+		headings []Heading
+		refs     []Ref
+	}{
+		{
+			name: "nested templates",
+			src:  "{{Infobox person|name=Ada|birth_place=[[London]]|spouse={{marriage|[[William King|William]]|1835}}}}Ada was a mathematician.",
+			text: "Ada was a mathematician.",
+			links: []Link{
+				{Target: "London", Label: "London"},
+				{Target: "William King", Label: "William"},
+			},
+		},
+		{
+			name: "inline templates inside inline templates",
+			src:  "A {{nowrap|{{lang|fr|très}} bien}} day.",
+			text: "A très bien day.",
+		},
+		{
+			name:  "template links come after the text's",
+			src:   "See [[Paris]].{{Navbox|list=[[Lyon]]}}",
+			text:  "See Paris.",
+			links: []Link{{Target: "Paris", Label: "Paris"}, {Target: "Lyon", Label: "Lyon"}},
+		},
+		{
+			name: "template parameters",
+			src:  "Value {{{1|default}}} here",
+			text: "Value here",
+		},
+		{
+			name:  "table",
+			src:   "{|\n|-\n! Year !! Title\n|-\n| style=\"x\" | 1843 || [[Notes]]\n|}\nAfter.",
+			text:  "Year Title\n1843 Notes\nAfter.",
+			links: []Link{{Target: "Notes", Label: "Notes"}},
+		},
+		{
+			name: "refs",
+			src:  "Fact.<ref name=\"a\">Source ''book''</ref> More<ref name=\"a\" /> text.",
+			text: "Fact. More text.",
+			refs: []Ref{{Name: "a", Text: "Source book"}, {Name: "a"}},
+		},
+		{
+			name: "comments",
+			src:  "Hidden<!-- comment [[Nope]] --> text<!-- never closed",
+			text: "Hidden text",
+		},
+		{
+			name: "nowiki",
+			src:  "<nowiki>[[Not a link]] {{nor this}}</nowiki>",
+			text: "[[Not a link]] {{nor this}}",
+		},
+		{
+			name: "headings",
+			src:  "Intro.\n== History ==\nOld.\n=== Early ===\nEarlier.\n===\nTail.\n==Uneven===",
+			text: "Intro.\nHistory\nOld.\nEarly\nEarlier.\nTail.\nUneven=",
+			headings: []Heading{
+				{Title: "History", Level: 2, Offset: 7},
+				{Title: "Early", Level: 3, Offset: 20},
+				{Title: "Uneven=", Level: 2, Offset: 41},
+			},
+		},
+		{
+			name: "file, category and interwiki links",
+			src:  "[[File:Ada.jpg|thumb|A [[portrait]]]] Text [[Category:Mathematicians|Lovelace]] [[:Category:Women]] [[de:Ada Lovelace]] [[wikt:analytic|analytic]] engine",
+			text: "Text Category:Women analytic engine",
+			links: []Link{
+				{Target: "Mathematicians", Label: "Lovelace", Namespace: NSCategory},
+			},
+		},
+		{
+			name: "pipe trick and link trail",
+			src:  "[[Paris (band)|]] and [[Help:Pipe trick|]] and [[bus]]es and [[History#Early|]]",
+			text: "Paris and Pipe trick and buses and History",
+			links: []Link{
+				{Target: "Paris (band)", Label: "Paris"},
+				{Target: "Pipe trick", Label: "Pipe trick", Namespace: NSHelp},
+				{Target: "Bus", Label: "buses"},
+				{Target: "History", Fragment: "Early", Label: "History"},
+			},
+		},
+		{
+			name: "unbalanced braces",
+			src:  "Start {{ broken and text {{done}} end",
+			text: "Start broken and text end",
+		},
+		{
+			name:  "unbalanced brackets",
+			src:   "Ok then [[ stray and [[Real link]] end",
+			text:  "Ok then stray and Real link end",
+			links: []Link{{Target: "Real link", Label: "Real link"}},
+		},
+		{
+			name:  "unbalanced both",
+			src:   "{{ {{ {{ deep [[a]] [[",
+			text:  "deep a",
+			links: []Link{{Target: "A", Label: "a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := Parse(tt.src)
+
+			if page.Text != tt.text {
+				t.Errorf("text = %q, want %q", page.Text, tt.text)
+			}
+			if !reflect.DeepEqual(page.Links, tt.links) {
+				t.Errorf("links = %+v, want %+v", page.Links, tt.links)
+			}
+			if !reflect.DeepEqual(page.Headings, tt.headings) {
+				t.Errorf("headings = %+v, want %+v", page.Headings, tt.headings)
+			}
+			if !reflect.DeepEqual(page.Refs, tt.refs) {
+				t.Errorf("refs = %+v, want %+v", page.Refs, tt.refs)
+			}
+		})
+	}
+}
+
+// stray delimiters used to be matched by scanning to the end of the page
+// for every one of them. ten times the input may take a lot more than ten
+// times as long on a busy machine, but nowhere near the hundred a
+// quadratic scan needs
+func TestParseUnbalancedIsLinear(t *testing.T) {
+	for _, open := range []string{"{{", "[[", "{{{", "<ref>"} {
+		small := parseTime(strings.Repeat("word "+open+" ", 2000))
+		large := parseTime(strings.Repeat("word "+open+" ", 20000))
+
+		if ratio := float64(large) / float64(small); ratio > 40 {
+			t.Errorf("stray %s: 10x the input took %.0fx as long (%v vs %v)", open, ratio, large, small)
+		}
+	}
+}
+
+// parseTime is the fastest of a few runs, so one slow run doesn't count
+func parseTime(src string) time.Duration {
+	best := time.Duration(math.MaxInt64)
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		Parse(src)
+		best = min(best, time.Since(start))
+	}
+
+	return max(best, time.Microsecond)
+}
+
+func BenchmarkParseUnbalanced(b *testing.B) {
+	src := strings.Repeat("word {{ [[ <ref> ", 20000)
+	for i := 0; i < b.N; i++ {
+		Parse(src)
+	}
+}
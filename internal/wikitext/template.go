@@ -0,0 +1,238 @@
+package wikitext
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	tagRe     = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9]*)((?:\s[^<>]*?)?)(/?)>`)
+	refNameRe = regexp.MustCompile(`(?i)\bname\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s/>]+))`)
+
+	// tags whose body never shows up as article text
+	dropTags = map[string]bool{
+		"math": true, "chem": true, "ce": true, "gallery": true, "timeline": true,
+		"imagemap": true, "score": true, "graph": true, "templatedata": true,
+		"includeonly": true, "syntaxhighlight": true, "source": true, "hiero": true,
+		"mapframe": true, "maplink": true, "categorytree": true, "inputbox": true,
+		"charinsert": true, "style": true, "script": true,
+	}
+
+	// tags that sit inside a sentence, everything else separates words
+	inlineTags = map[string]bool{
+		"span": true, "sup": true, "sub": true, "small": true, "big": true,
+		"b": true, "i": true, "u": true, "s": true, "code": true, "abbr": true,
+		"font": true, "tt": true, "em": true, "strong": true, "del": true,
+		"ins": true, "var": true, "kbd": true, "mark": true, "q": true,
+		"cite": true, "onlyinclude": true, "noinclude": true, "bdi": true,
+	}
+
+	// templates rendered as text, keyed by lowercase name
+	inlineTemplates = map[string]func(t *Template) string{
+		"lang":    func(t *Template) string { return t.Positional(1) },
+		"nowrap":  func(t *Template) string { return t.Positional(0) },
+		"nobr":    func(t *Template) string { return t.Positional(0) },
+		"small":   func(t *Template) string { return t.Positional(0) },
+		"big":     func(t *Template) string { return t.Positional(0) },
+		"ill":     func(t *Template) string { return t.Positional(0) },
+		"convert": func(t *Template) string { return t.Positional(0) + " " + t.Positional(1) },
+		"cvt":     func(t *Template) string { return t.Positional(0) + " " + t.Positional(1) },
+	}
+
+	// parser functions that pass their argument through as text
+	textFunctions = []string{"formatnum:", "lc:", "uc:", "lcfirst:", "ucfirst:"}
+)
+
+// expand removes templates, refs, nowiki and html tags, leaving wikitext
+// that only has block markup, links and formatting left in it
+func (st *state) expand(s string) string {
+	var b strings.Builder
+
+	templates := newClosers(s, "{{", "}}")
+	// once a closing "}}}" or </tag> isn't found, there's none further on
+	// either, no need to look again
+	paramsClosed := true
+	unclosed := make(map[string]bool)
+
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "{{{"):
+			// template parameters only make sense on template pages
+			end := -1
+			if paramsClosed {
+				end = strings.Index(s[i+3:], "}}}")
+				paramsClosed = end >= 0
+			}
+			if end < 0 {
+				i += 3
+				continue
+			}
+			i += end + 6
+
+		case strings.HasPrefix(s[i:], "{{"):
+			end := templates.at(i)
+			if end < 0 {
+				i += 2
+				continue
+			}
+			b.WriteString(st.template(s[i+2 : end-2]))
+			i = end
+
+		case s[i] == '<':
+			n, out := st.tag(s[i:], unclosed)
+			b.WriteString(out)
+			i += n
+
+		default:
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+func (st *state) template(inner string) string {
+	parts := splitTopLevel(inner, '|')
+
+	name := strings.Join(strings.Fields(strings.ReplaceAll(parts[0], "_", " ")), " ")
+	for _, prefix := range []string{"subst:", "safesubst:", "template:"} {
+		if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			name = strings.TrimSpace(name[len(prefix):])
+		}
+	}
+
+	t := Template{Name: name}
+	for _, part := range parts[1:] {
+		arg := Arg{Raw: part}
+		if eq := indexTopLevel(part, '='); eq >= 0 {
+			arg.Name = strings.TrimSpace(part[:eq])
+			arg.Raw = part[eq+1:]
+		}
+		arg.Raw = strings.TrimSpace(arg.Raw)
+		arg.Value = st.nested(arg.Raw)
+		t.Args = append(t.Args, arg)
+	}
+	st.page.Templates = append(st.page.Templates, t)
+
+	lower := strings.ToLower(name)
+	for _, fn := range textFunctions {
+		if strings.HasPrefix(lower, fn) {
+			return nowikiEscape.Replace(st.nested(name[len(fn):]))
+		}
+	}
+	if render, ok := inlineTemplates[lower]; ok {
+		return nowikiEscape.Replace(render(&t))
+	}
+	if strings.HasPrefix(lower, "lang-") {
+		return nowikiEscape.Replace(t.Positional(0))
+	}
+
+	return ""
+}
+
+// nested parses a template argument on its own and returns its text. the
+// links in it are the page's too, infobox fields are full of them
+func (st *state) nested(src string) string {
+	page := st.p.Parse(src)
+	st.argLinks = append(st.argLinks, page.Links...)
+
+	return page.Text
+}
+
+// tag handles an html-ish tag at the start of s and returns how many bytes
+// it consumed and what to put in its place. unclosed has the tags already
+// known to have no closing tag in the rest of the text
+func (st *state) tag(s string, unclosed map[string]bool) (int, string) {
+	m := tagRe.FindStringSubmatch(s)
+	if m == nil {
+		return 1, "<"
+	}
+
+	n := len(m[0])
+	closing, name, attrs, selfClosing := m[1] != "", strings.ToLower(m[2]), m[3], m[4] != ""
+
+	if closing {
+		return n, tagSeparator(name)
+	}
+
+	if name == "br" || name == "hr" || name == "references" && selfClosing {
+		return n, " "
+	}
+
+	if name == "ref" && selfClosing {
+		st.page.Refs = append(st.page.Refs, Ref{Name: refName(attrs)})
+		return n, ""
+	}
+
+	if name != "nowiki" && name != "ref" && name != "references" && !dropTags[name] {
+		return n, tagSeparator(name)
+	}
+
+	if selfClosing {
+		return n, ""
+	}
+
+	body, end := "", -1
+	if !unclosed[name] {
+		body, end = tagBody(s[n:], name)
+	}
+	if end < 0 {
+		// unclosed, so only the opening tag goes
+		unclosed[name] = true
+		return n, ""
+	}
+
+	switch name {
+	case "nowiki":
+		return n + end, nowikiEscape.Replace(body)
+
+	case "ref":
+		st.page.Refs = append(st.page.Refs, Ref{Name: refName(attrs), Text: st.p.Parse(body).Text})
+
+	case "references":
+		// list-defined refs live in here
+		st.expand(body)
+	}
+
+	return n + end, ""
+}
+
+func tagSeparator(name string) string {
+	if inlineTags[name] {
+		return ""
+	}
+
+	return " "
+}
+
+// tagBody returns the content up to the closing </name> and the index just
+// past it, or -1 when the tag is never closed
+func tagBody(s, name string) (string, int) {
+	for i := 0; i < len(s); i++ {
+		j := strings.Index(s[i:], "</")
+		if j < 0 {
+			return "", -1
+		}
+		i += j
+
+		rest := s[i+2:]
+		if len(rest) >= len(name) && strings.EqualFold(rest[:len(name)], name) {
+			after := strings.TrimLeft(rest[len(name):], " \t\n")
+			if strings.HasPrefix(after, ">") {
+				return s[:i], len(s) - len(after) + 1
+			}
+		}
+	}
+
+	return "", -1
+}
+
+func refName(attrs string) string {
+	m := refNameRe.FindStringSubmatch(attrs)
+	if m == nil {
+		return ""
+	}
+
+	return m[1] + m[2] + m[3]
+}